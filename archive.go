@@ -0,0 +1,495 @@
+package pipeline
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zip"
+)
+
+// archiveMember is a file inside of an archive.
+type archiveMember struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// archive iterates over the members of a tar or zip archive.
+type archive struct {
+	// next returns the next member, io.EOF after the last one
+	next  func() (archiveMember, error)
+	close func() error
+
+	// sum of the member sizes, -1 if unknown
+	size int64
+}
+
+// openArchive opens a zip or a tar archive, tar archives may be
+// compressed with any codec detected by DecompressAuto.
+// Only regular files matching memberGlob are returned, an empty
+// memberGlob matches all members. The glob is matched against
+// the full name of the member with path.Match.
+func openArchive(name string, memberGlob string) (*archive, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, 4)
+	n, _ := io.ReadFull(file, magic)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if bytes.Equal(magic[:n], []byte("PK\x03\x04")) || bytes.Equal(magic[:n], []byte("PK\x05\x06")) {
+		return openZipArchive(file, memberGlob)
+	}
+
+	return openTarArchive(file, memberGlob)
+}
+
+func matchMember(memberGlob string, name string) bool {
+	if memberGlob == "" {
+		return true
+	}
+
+	matched, _ := path.Match(memberGlob, name)
+	return matched
+}
+
+func openZipArchive(file *os.File, memberGlob string) (*archive, error) {
+	stats, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r, err := zip.NewReader(file, stats.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	var members []archiveMember
+	var size int64
+
+	for _, f := range r.File {
+		if !f.Mode().IsRegular() || !matchMember(memberGlob, f.Name) {
+			continue
+		}
+
+		members = append(members, archiveMember{
+			name: f.Name,
+			size: int64(f.UncompressedSize64),
+			open: f.Open,
+		})
+		size += int64(f.UncompressedSize64)
+	}
+
+	return &archive{
+		next: func() (archiveMember, error) {
+			if len(members) == 0 {
+				return archiveMember{}, io.EOF
+			}
+
+			member := members[0]
+			members = members[1:]
+			return member, nil
+		},
+		close: file.Close,
+		size:  size,
+	}, nil
+}
+
+func openTarArchive(file *os.File, memberGlob string) (*archive, error) {
+	decompressed, err := newAutoDecompressor(file, source{name: file.Name()})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	tr := tar.NewReader(decompressed)
+
+	return &archive{
+		next: func() (archiveMember, error) {
+			for {
+				header, err := tr.Next()
+				if err != nil {
+					return archiveMember{}, err
+				}
+
+				if header.Typeflag != tar.TypeReg || !matchMember(memberGlob, header.Name) {
+					continue
+				}
+
+				return archiveMember{
+					name: header.Name,
+					size: header.Size,
+					open: func() (io.ReadCloser, error) {
+						return io.NopCloser(tr), nil
+					},
+				}, nil
+			}
+		},
+		close: func() error {
+			decompressed.Close()
+			return file.Close()
+		},
+		size: -1,
+	}, nil
+}
+
+func FromArchive(name string, memberGlob string, next ReaderWithSize, opts ...FilesOption) error {
+	return FromArchiveContext(context.Background(), name, memberGlob, next, opts...)
+}
+
+// FromArchiveContext reads the members of a tar or zip archive matching
+// memberGlob one after another as a single stream, see openArchive.
+// The size is the sum of the member sizes for zip archives
+// and unknown for tar archives. The member names are not passed on,
+// as the stages read the stream behind the member being read.
+// Use ForEachArchiveMember if the parser needs the member name.
+func FromArchiveContext(ctx context.Context, name string, memberGlob string, next ReaderWithSize, opts ...FilesOption) error {
+	config := newFilesConfig(opts)
+
+	a, err := openArchive(name, memberGlob)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	reader := &filesReader{
+		next: func() (string, io.ReadCloser, error) {
+			member, err := a.next()
+			if err != nil {
+				return "", nil, err
+			}
+
+			r, err := member.open()
+			return member.name, r, err
+		},
+		config: config,
+	}
+	defer reader.Close()
+
+	size := a.size
+	if config.decompressAuto || config.decompress != NoCompression {
+		size = -1
+	}
+
+	ctx = withSource(ctx, source{name: name})
+
+	return next(WithContext(ctx, reader), size)
+}
+
+var _ Pipeline = &forEachMember{}
+
+// ForEachArchiveMember builds a pipeline for every member of a tar or zip
+// archive matching memberGlob and executes them one after another.
+// The input of the pipeline is already set to the member. build gets
+// the member name, so a LineParser can capture it to know its member.
+// All members are processed even if some fail,
+// the failures are returned as FileErrors keyed by member name.
+func ForEachArchiveMember(name string, memberGlob string, build func(member string, input InputBuilder) Pipeline) Pipeline {
+	return &forEachMember{
+		name:       name,
+		memberGlob: memberGlob,
+		build:      build,
+	}
+}
+
+type forEachMember struct {
+	name       string
+	memberGlob string
+	build      func(member string, input InputBuilder) Pipeline
+}
+
+// Execute implements Pipeline.
+func (f *forEachMember) Execute() error {
+	return f.ExecuteContext(context.Background())
+}
+
+// ExecuteContext implements Pipeline.
+func (f *forEachMember) ExecuteContext(ctx context.Context) error {
+	a, err := openArchive(f.name, f.memberGlob)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	errs := make(FileErrors)
+
+	for ctx.Err() == nil {
+		member, err := a.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		input := newInputBuilder()
+		input.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+			r, err := member.open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			ctx = withSource(ctx, source{name: member.name})
+			return next(WithContext(ctx, r), member.size)
+		}
+
+		if err := f.build(member.name, input).ExecuteContext(ctx); err != nil {
+			errs[member.name] = err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return ctx.Err()
+}
+
+// ArchiveFormat is the format of an archive written by ToArchive.
+type ArchiveFormat int
+
+const (
+	ArchiveTar ArchiveFormat = iota
+	ArchiveTarGzip
+	ArchiveZip
+)
+
+// archiveWriter writes members into an archive.
+// Members are spooled into temporary files first,
+// so they can be written concurrently.
+type archiveWriter struct {
+	mu sync.Mutex
+
+	file *os.File
+	gz   *gzip.Writer
+	tar  *tar.Writer
+	zip  *zip.Writer
+}
+
+func createArchive(name string, format ArchiveFormat) (*archiveWriter, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &archiveWriter{file: file}
+
+	switch format {
+	case ArchiveTar:
+		a.tar = tar.NewWriter(file)
+	case ArchiveTarGzip:
+		a.gz = gzip.NewWriter(file)
+		a.tar = tar.NewWriter(a.gz)
+	case ArchiveZip:
+		a.zip = zip.NewWriter(file)
+	default:
+		file.Close()
+		os.Remove(name)
+		return nil, fmt.Errorf("archive: unsupported format %d", format)
+	}
+
+	return a, nil
+}
+
+// create returns a writer for the member, which is added
+// to the archive when it is closed without an error.
+func (a *archiveWriter) create(name string) (*archiveMemberWriter, error) {
+	spool, err := os.CreateTemp("", "pipeline-member-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &archiveMemberWriter{
+		File:    spool,
+		archive: a,
+		name:    name,
+	}, nil
+}
+
+func (a *archiveWriter) add(name string, spool *os.File) error {
+	stats, err := spool.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var w io.Writer
+	if a.tar != nil {
+		err = a.tar.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    stats.Size(),
+			ModTime: stats.ModTime(),
+		})
+		w = a.tar
+	} else {
+		w, err = a.zip.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: stats.ModTime(),
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, spool)
+	return err
+}
+
+// close finishes the archive, if err is not nil the archive is removed.
+func (a *archiveWriter) close(err error) error {
+	if a.tar != nil {
+		if cerr := a.tar.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if a.gz != nil {
+		if cerr := a.gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if a.zip != nil {
+		if cerr := a.zip.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(a.file.Name())
+	}
+
+	return err
+}
+
+// archiveMemberWriter spools a member of an archive.
+type archiveMemberWriter struct {
+	*os.File
+	archive *archiveWriter
+	name    string
+}
+
+// close adds the member to the archive if err is nil
+// and removes the spooled file.
+func (m *archiveMemberWriter) close(err error) error {
+	if err == nil {
+		err = m.archive.add(m.name, m.File)
+	}
+
+	m.File.Close()
+	os.Remove(m.File.Name())
+
+	return err
+}
+
+// archiveSink holds the archive of the current execution,
+// it is shared by all outputs writing into the same archive.
+type archiveSink struct {
+	name   string
+	format ArchiveFormat
+
+	current *archiveWriter
+}
+
+func (s *archiveSink) open() error {
+	a, err := createArchive(s.name, s.format)
+	if err != nil {
+		return err
+	}
+
+	s.current = a
+	return nil
+}
+
+func (s *archiveSink) close(err error) error {
+	err = s.current.close(err)
+	s.current = nil
+
+	return err
+}
+
+// member writes the stream into the member of the current archive,
+// the name is normalised by archiveMemberName.
+func (s *archiveSink) member(name string, before Connector) Reader {
+	return func(r io.Reader) error {
+		name, err := archiveMemberName(name)
+		if err != nil {
+			return err
+		}
+
+		m, err := s.current.create(name)
+		if err != nil {
+			return err
+		}
+
+		return m.close(before(m, r))
+	}
+}
+
+// archiveMemberName turns a file path into a relative slash separated
+// member name. The volume and leading slashes are removed, so the archive
+// does not reveal absolute paths, and names leaving the archive fail.
+func archiveMemberName(name string) (string, error) {
+	member := filepath.ToSlash(strings.TrimPrefix(name, filepath.VolumeName(name)))
+	member = path.Clean(strings.TrimLeft(member, "/"))
+
+	if member == "." || member == ".." || strings.HasPrefix(member, "../") {
+		return "", fmt.Errorf("archive: invalid member name %q", name)
+	}
+
+	return member, nil
+}
+
+// memberName derives the name of the only member
+// of an archive from the name of the archive.
+func memberName(name string) string {
+	name = filepath.Base(name)
+
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+
+	return name
+}
+
+// ToArchive writes the stream as the only member of a new archive,
+// the member is named after the archive without its extension.
+func ToArchive(name string, format ArchiveFormat, before Connector) Reader {
+	sink := &archiveSink{name: name, format: format}
+	member := sink.member(memberName(name), before)
+
+	return func(r io.Reader) error {
+		if err := sink.open(); err != nil {
+			return err
+		}
+
+		return sink.close(member(r))
+	}
+}
@@ -0,0 +1,214 @@
+package pipeline_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+var archiveMembers = []struct {
+	name    string
+	content string
+}{
+	{"data/a.csv", "a1\na2\n"},
+	{"data/readme.txt", "ignored\n"},
+	{"data/b.csv", "b1\n"},
+}
+
+func writeTarGz(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	defer gz.Close()
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+
+	for _, m := range archiveMembers {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.content))}))
+		tw.Write([]byte(m.content))
+	}
+
+	return path
+}
+
+func writeZip(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "bundle.zip")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	zw := zip.NewWriter(file)
+	defer zw.Close()
+
+	for _, m := range archiveMembers {
+		w, err := zw.Create(m.name)
+		assert.NoError(t, err)
+		w.Write([]byte(m.content))
+	}
+
+	return path
+}
+
+func TestFromArchive(t *testing.T) {
+	for format, path := range map[string]string{"tar.gz": writeTarGz(t), "zip": writeZip(t)} {
+		t.Run(format, func(t *testing.T) {
+			var out strings.Builder
+
+			err := pipeline.Build().
+				FromArchive(path, "data/*.csv").
+				ToWriter(&out).
+				Build().
+				Execute()
+
+			assert.NoError(t, err)
+			assert.Equal(t, "a1\na2\nb1\n", out.String())
+		})
+	}
+}
+
+func TestForEachArchiveMember(t *testing.T) {
+	for format, path := range map[string]string{"tar.gz": writeTarGz(t), "zip": writeZip(t)} {
+		t.Run(format, func(t *testing.T) {
+			var mu sync.Mutex
+			var lines []string
+
+			err := pipeline.ForEachArchiveMember(path, "data/*.csv", func(member string, input pipeline.InputBuilder) pipeline.Pipeline {
+				return input.
+					ParseLines(func(line string) ([]byte, error) {
+						mu.Lock()
+						defer mu.Unlock()
+						lines = append(lines, member+":"+line)
+						return nil, nil
+					}).
+					ReadOnly().
+					Build()
+			}).Execute()
+
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"data/a.csv:a1", "data/a.csv:a2", "data/b.csv:b1"}, lines)
+		})
+	}
+}
+
+// readArchive returns the members of a tar.gz or zip archive.
+func readArchive(t *testing.T, path string) map[string]string {
+	outputs := make(map[string]*strings.Builder)
+
+	err := pipeline.ForEachArchiveMember(path, "", func(member string, input pipeline.InputBuilder) pipeline.Pipeline {
+		outputs[member] = &strings.Builder{}
+		return input.ToWriter(outputs[member]).Build()
+	}).Execute()
+	assert.NoError(t, err)
+
+	members := make(map[string]string)
+	for member, out := range outputs {
+		members[member] = out.String()
+	}
+
+	return members
+}
+
+func TestFanoutToArchive(t *testing.T) {
+	formats := map[string]pipeline.ArchiveFormat{
+		"bundle.tar.gz": pipeline.ArchiveTarGzip,
+		"bundle.zip":    pipeline.ArchiveZip,
+	}
+
+	for name, format := range formats {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			r := strings.NewReader("a,b\nc,d\n")
+
+			err := pipeline.Build().
+				FromReader(r, r.Size()).
+				Fanout().
+				ToArchive(path, format).
+				Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+					return output.ToFile("raw.csv").Build()
+				}).
+				Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+					return output.ToFile("header.csv").Preamble("x,y\n").Build()
+				}).
+				Build().
+				Execute()
+
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{
+				"raw.csv":    "a,b\nc,d\n",
+				"header.csv": "x,y\na,b\nc,d\n",
+			}, readArchive(t, path))
+		})
+	}
+}
+
+func TestFanoutToArchiveAfterRegister(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.zip")
+	r := strings.NewReader("a,b\n")
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		Fanout().
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToFile("raw.csv").Build()
+		}).
+		ToArchive(path, pipeline.ArchiveZip).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"raw.csv": "a,b\n"}, readArchive(t, path))
+	assert.NoFileExists(t, "raw.csv")
+}
+
+func TestFanoutToArchiveMemberNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bundle.tar")
+
+	bundle := func(name string) error {
+		r := strings.NewReader("a,b\n")
+
+		return pipeline.Build().
+			FromReader(r, r.Size()).
+			Fanout().
+			ToArchive(path, pipeline.ArchiveTar).
+			Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+				return output.ToFile(name).Build()
+			}).
+			Build().
+			Execute()
+	}
+
+	assert.NoError(t, bundle(filepath.Join(dir, "x", "out.txt")))
+	assert.Equal(t, map[string]string{strings.TrimPrefix(filepath.ToSlash(dir), "/") + "/x/out.txt": "a,b\n"}, readArchive(t, path))
+
+	assert.NoError(t, bundle("x/../y/./out.txt"))
+	assert.Equal(t, map[string]string{"y/out.txt": "a,b\n"}, readArchive(t, path))
+
+	assert.ErrorContains(t, bundle("../../etc/evil"), "invalid member name")
+	assert.ErrorContains(t, bundle("x/../../evil"), "invalid member name")
+}
+
+func TestToArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.tar")
+	r := strings.NewReader("content")
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		ToArchive(path, pipeline.ArchiveTar).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"export": "content"}, readArchive(t, path))
+}
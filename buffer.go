@@ -0,0 +1,298 @@
+package pipeline
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+)
+
+// BufferStats reports the usage of the buffer of a fanout branch.
+// It is handed to the stats function of FanoutBuilder.Buffer from the
+// goroutine of the branch, so the function has to be safe for concurrent use.
+type BufferStats struct {
+	// index of the branch in the order the branches were added
+	Branch int
+	// most bytes held by the buffer at once
+	HighWaterMark int64
+	// bytes written to disk because the memory of the buffer was full
+	Spilled int64
+}
+
+// bufferConfig configures the buffers of the fanout branches.
+type bufferConfig struct {
+	memory int64
+	disk   int64
+	stats  func(BufferStats)
+}
+
+// pipeWriter is the writing half of a pipe like io.PipeWriter.
+type pipeWriter interface {
+	io.Writer
+	CloseWithError(err error) error
+}
+
+// pipeReader is the reading half of a pipe like io.PipeReader.
+type pipeReader interface {
+	io.Reader
+	CloseWithError(err error) error
+}
+
+// newPipe creates a synchronous io.Pipe or,
+// if configured, a pipe buffering the stream.
+func (c *bufferConfig) newPipe() (pipeReader, pipeWriter, *spillBuffer) {
+	if c == nil {
+		reader, writer := io.Pipe()
+		return reader, writer, nil
+	}
+
+	b := newSpillBuffer(c.memory, c.disk)
+	return &bufferReader{b}, &bufferWriter{b}, b
+}
+
+// spillBuffer is a pipe holding up to memory bytes in memory and
+// spilling up to disk further bytes into a temporary file.
+// Writes block while the buffer is full.
+type spillBuffer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	memoryLimit int64
+	diskLimit   int64
+
+	memory bytes.Buffer
+
+	// the file is used as ring buffer of size diskLimit,
+	// it only holds data while the memory is full
+	file      *os.File
+	diskStart int64
+	diskLen   int64
+
+	// errors the pipe was closed with by the writer and the reader
+	writeErr error
+	readErr  error
+
+	highWaterMark int64
+	spilled       int64
+}
+
+func newSpillBuffer(memory, disk int64) *spillBuffer {
+	b := &spillBuffer{
+		memoryLimit: memory,
+		diskLimit:   disk,
+	}
+	b.cond = sync.NewCond(&b.mu)
+
+	return b
+}
+
+func (b *spillBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		if b.readErr != nil {
+			return written, b.readErr
+		} else if b.writeErr != nil {
+			return written, io.ErrClosedPipe
+		}
+
+		n, err := b.put(p)
+		if err != nil {
+			return written, err
+		}
+
+		if n == 0 {
+			// wait for the reader to make room
+			b.cond.Wait()
+			continue
+		}
+
+		written += n
+		p = p[n:]
+
+		b.highWaterMark = max(b.highWaterMark, int64(b.memory.Len())+b.diskLen)
+		b.cond.Broadcast()
+	}
+
+	return written, nil
+}
+
+// put stores as much of p as fits into the buffer. To keep the
+// order of the stream, memory is only used while nothing is spilled.
+func (b *spillBuffer) put(p []byte) (int, error) {
+	if room := b.memoryLimit - int64(b.memory.Len()); b.diskLen == 0 && room > 0 {
+		n := min(int64(len(p)), room)
+		b.memory.Write(p[:n])
+		return int(n), nil
+	}
+
+	if room := b.diskLimit - b.diskLen; room > 0 {
+		n := min(int64(len(p)), room)
+		if err := b.spill(p[:n]); err != nil {
+			return 0, err
+		}
+		return int(n), nil
+	}
+
+	return 0, nil
+}
+
+// spill appends p to the ring buffer in the file.
+func (b *spillBuffer) spill(p []byte) error {
+	if b.file == nil {
+		file, err := os.CreateTemp("", "pipeline-buffer-*")
+		if err != nil {
+			return err
+		}
+		b.file = file
+	}
+
+	pos := (b.diskStart + b.diskLen) % b.diskLimit
+	first := min(int64(len(p)), b.diskLimit-pos)
+
+	if _, err := b.file.WriteAt(p[:first], pos); err != nil {
+		return err
+	}
+	if _, err := b.file.WriteAt(p[first:], 0); err != nil {
+		return err
+	}
+
+	b.diskLen += int64(len(p))
+	b.spilled += int64(len(p))
+	return nil
+}
+
+func (b *spillBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for {
+		if b.readErr != nil {
+			return 0, io.ErrClosedPipe
+		} else if b.writeErr != nil && b.writeErr != io.EOF {
+			// failures are handed on right away
+			return 0, b.writeErr
+		}
+
+		if b.memory.Len() > 0 {
+			n, _ := b.memory.Read(p)
+			b.cond.Broadcast()
+			return n, nil
+		}
+
+		if b.diskLen > 0 {
+			n, err := b.unspill(p)
+			b.cond.Broadcast()
+			return n, err
+		}
+
+		if b.writeErr != nil {
+			return 0, b.writeErr
+		}
+
+		b.cond.Wait()
+	}
+}
+
+// unspill reads from the ring buffer in the file.
+func (b *spillBuffer) unspill(p []byte) (int, error) {
+	n := min(int64(len(p)), b.diskLen)
+	first := min(n, b.diskLimit-b.diskStart)
+
+	if _, err := b.file.ReadAt(p[:first], b.diskStart); err != nil {
+		return 0, err
+	}
+	if _, err := b.file.ReadAt(p[first:n], 0); err != nil {
+		return 0, err
+	}
+
+	b.diskStart = (b.diskStart + n) % b.diskLimit
+	b.diskLen -= n
+	if b.diskLen == 0 {
+		b.diskStart = 0
+	}
+
+	return int(n), nil
+}
+
+// closeWrite closes the writing side, the reader
+// receives the buffered data and then err or io.EOF.
+func (b *spillBuffer) closeWrite(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		err = io.EOF
+	}
+
+	if b.writeErr == nil {
+		b.writeErr = err
+	}
+	b.cond.Broadcast()
+}
+
+// closeRead closes the reading side and removes the temporary file,
+// pending and further writes fail with err or io.ErrClosedPipe.
+func (b *spillBuffer) closeRead(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		err = io.ErrClosedPipe
+	}
+
+	if b.readErr == nil {
+		b.readErr = err
+	}
+
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+		b.file = nil
+	}
+
+	b.memory = bytes.Buffer{}
+	b.diskLen = 0
+	b.cond.Broadcast()
+}
+
+func (b *spillBuffer) stats(branch int) BufferStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return BufferStats{
+		Branch:        branch,
+		HighWaterMark: b.highWaterMark,
+		Spilled:       b.spilled,
+	}
+}
+
+// bufferWriter is the writing half of a spillBuffer.
+type bufferWriter struct {
+	b *spillBuffer
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	return w.b.write(p)
+}
+
+func (w *bufferWriter) CloseWithError(err error) error {
+	w.b.closeWrite(err)
+	return nil
+}
+
+// bufferReader is the reading half of a spillBuffer.
+type bufferReader struct {
+	b *spillBuffer
+}
+
+func (r *bufferReader) Read(p []byte) (int, error) {
+	return r.b.read(p)
+}
+
+func (r *bufferReader) CloseWithError(err error) error {
+	r.b.closeRead(err)
+	return nil
+}
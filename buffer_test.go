@@ -0,0 +1,131 @@
+package pipeline_test
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFanoutBuffer(t *testing.T) {
+	var b strings.Builder
+	for i := 0; b.Len() < 100_000; i++ {
+		b.WriteString(strconv.Itoa(i) + "\n")
+	}
+	content := b.String()
+
+	fastDone := make(chan struct{})
+	var fast, slow strings.Builder
+
+	var mu sync.Mutex
+	stats := make(map[int]pipeline.BufferStats)
+
+	r := strings.NewReader(content)
+	err := pipeline.Build().FromReader(r, r.Size()).
+		Fanout().
+		Buffer(1024, 1<<20, func(s pipeline.BufferStats) {
+			mu.Lock()
+			defer mu.Unlock()
+			stats[s.Branch] = s
+		}).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&fast).
+				AddProcessingStep(func(next pipeline.Reader) pipeline.Reader {
+					return func(r io.Reader) error {
+						defer close(fastDone)
+						return next(r)
+					}
+				}).Build()
+		}).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			// only starts reading once the fast branch completed,
+			// without the buffer the fanout would be stuck
+			return output.ToWriter(&slow).
+				AddProcessingStep(func(next pipeline.Reader) pipeline.Reader {
+					return func(r io.Reader) error {
+						<-fastDone
+						return next(r)
+					}
+				}).Build()
+		}).
+		Build().Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, content, fast.String())
+	assert.Equal(t, content, slow.String())
+
+	assert.Len(t, stats, 2)
+	assert.Equal(t, int64(len(content)), stats[1].HighWaterMark)
+	assert.Equal(t, int64(len(content)-1024), stats[1].Spilled)
+}
+
+func TestFanoutBufferBounded(t *testing.T) {
+	var b strings.Builder
+	for i := 0; b.Len() < 100_000; i++ {
+		b.WriteString(strconv.Itoa(i) + "\n")
+	}
+	content := b.String()
+
+	var mu sync.Mutex
+	var highWaterMark int64
+
+	outputs := make([]strings.Builder, 3)
+	builder := pipeline.Build().FromReader(strings.NewReader(content), int64(len(content))).
+		Fanout().
+		Buffer(16, 100, func(s pipeline.BufferStats) {
+			mu.Lock()
+			defer mu.Unlock()
+			highWaterMark = max(highWaterMark, s.HighWaterMark)
+		})
+
+	for i := range outputs {
+		out := &outputs[i]
+		builder.Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(out).Build()
+		})
+	}
+
+	err := builder.Build().Execute()
+	assert.NoError(t, err)
+
+	for i := range outputs {
+		assert.Equal(t, content, outputs[i].String())
+	}
+	assert.LessOrEqual(t, highWaterMark, int64(116))
+}
+
+func TestFanoutBufferWithoutRoom(t *testing.T) {
+	for _, size := range [][2]int64{{0, 0}, {-1, 0}, {0, -10}} {
+		var out strings.Builder
+		called := false
+
+		done := make(chan error, 1)
+		go func() {
+			r := strings.NewReader("a\nb\n")
+			done <- pipeline.Build().FromReader(r, r.Size()).
+				Fanout().
+				Buffer(size[0], size[1], func(s pipeline.BufferStats) {
+					called = true
+				}).
+				Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+					return output.ToWriter(&out).Build()
+				}).
+				Build().Execute()
+		}()
+
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+			assert.Equal(t, "a\nb\n", out.String())
+			// the branch is fed without a buffer
+			assert.False(t, called)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("fanout with buffer %v did not complete", size)
+		}
+	}
+}
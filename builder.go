@@ -1,7 +1,10 @@
 package pipeline
 
 import (
+	"context"
 	"io"
+	"io/fs"
+	"net/http"
 )
 
 func Build() Builder {
@@ -10,13 +13,36 @@ func Build() Builder {
 
 type Builder interface {
 	FromFile(path string) InputBuilder
-	FromWeb(url string) InputBuilder
+	// Read the named file of fsys, for example of an embed.FS
+	FromFS(fsys fs.FS, name string) InputBuilder
+	// Read the files one after another as a single stream
+	FromFiles(paths []string, opts ...FilesOption) InputBuilder
+	// Read all files matching the pattern in lexical order, see FromFiles
+	FromGlob(pattern string, opts ...FilesOption) InputBuilder
+	// Read the members of a tar or zip archive matching memberGlob as a single stream.
+	// The stream does not tell which member a line belongs to, use
+	// ForEachArchiveMember to build a pipeline per member that knows its name
+	FromArchive(name string, memberGlob string, opts ...FilesOption) InputBuilder
+	// Download url, use HTTPRetry to resume interrupted downloads
+	FromWeb(url string, opts ...HTTPOption) InputBuilder
+	// Send the request and read the response body,
+	// non 2xx responses fail with a HTTPStatusError. The request is
+	// cancelled by its own context as well as by the execution
+	FromHTTP(req *http.Request, opts ...HTTPOption) InputBuilder
 	FromReader(r io.Reader, size int64) InputBuilder
 }
 
 type InputBuilder interface {
-	// Enable decompression of the input with gzip
-	DecompressGzip(enable bool) InputBuilder
+	// Enable decompression of the input with gzip,
+	// use GzipMultistream to only read the first member
+	DecompressGzip(enable bool, opts ...GzipOption) InputBuilder
+
+	// Enable decompression of the input with the codec
+	Decompress(codec Codec) InputBuilder
+
+	// Detect the compression of the input,
+	// uncompressed inputs are passed through
+	DecompressAuto() InputBuilder
 
 	Decode(decoder Processor) InputBuilder
 
@@ -26,11 +52,16 @@ type InputBuilder interface {
 
 	OutputBuilder
 
-	// parsing
-	ParseLines(parser LineParser[[]byte]) InputBuilder
-	ParseLinesToGob(parser LineParser[interface{}]) InputBuilder
-	ParseLinesToJson(parser LineParser[interface{}]) InputBuilder
-	ParseLinesToCustomEncoder(encoder NewEncoder, parser LineParser[interface{}]) InputBuilder
+	// parsing, use Parallel to run the parser on multiple workers
+	ParseLines(parser LineParser[[]byte], opts ...StageOption) InputBuilder
+	ParseLinesToGob(parser LineParser[interface{}], opts ...StageOption) InputBuilder
+	ParseLinesToJson(parser LineParser[interface{}], opts ...StageOption) InputBuilder
+	ParseLinesToCustomEncoder(encoder NewEncoder, parser LineParser[interface{}], opts ...StageOption) InputBuilder
+
+	// Decide what happens to lines the parser of ParseLines or
+	// ParseLinesTo... rejected, by default the pipeline fails on the first
+	// one. Decode stages like DecodeNDJSON take the OnParseError option
+	OnParseError(policy ParseErrorPolicy) InputBuilder
 
 	Fanout() FanoutBuilder
 }
@@ -44,35 +75,84 @@ type OutputBuilder interface {
 	// Only read the pipeline
 	ReadOnly() ReadonlyBuilder
 
-	// Output the pipeline into a file
-	ToFile(path string) OutputConfigurationBuilder
+	// Output the pipeline into a file, use Atomic to replace the file
+	// only on success and FileSystem to write to something else than the local disk,
+	// see FileMode, MkdirAll, NoOverwrite and Sync for further options
+	ToFile(path string, opts ...FileOption) OutputConfigurationBuilder
 
 	// Output the pipeline into a new file or if exists append to it
-	AppendToFile(path string) OutputConfigurationBuilder
+	AppendToFile(path string, opts ...FileOption) OutputConfigurationBuilder
+
+	// Split the output on line boundaries into files named after pattern,
+	// like "part-%05d.json". Preamble, appendix and compression
+	// are applied to every file, see ToRotatingFiles
+	ToRotatingFiles(pattern string, maxBytes int64, maxLines int, opts ...FileOption) OutputConfigurationBuilder
 
 	// Output the pipeline into any io.Writer
 	ToWriter(w io.Writer) OutputConfigurationBuilder
+
+	// Output the records of the partitioner into a file per partition,
+	// preamble, appendix and compression are applied to every file, see ToPartitions.
+	// Within an archive every partition becomes a member, kept open until the end
+	ToPartitions(partitioner Partitioner, pathTemplate string, opts ...FileOption) OutputConfigurationBuilder
+
+	// Output the pipeline into a new tar or zip archive,
+	// the member is named after the archive without its extension
+	ToArchive(path string, format ArchiveFormat) OutputConfigurationBuilder
 }
 
 type OutputConfigurationBuilder interface {
 	Preamble(preamble string) OutputConfigurationBuilder
 	Appendix(appendix string) OutputConfigurationBuilder
-	CompressGzip(enable bool) OutputConfigurationBuilder
+	// Compress the output with gzip,
+	// use GzipLevel and GzipConcurrency to tune the compression
+	CompressGzip(enable bool, opts ...GzipOption) OutputConfigurationBuilder
+	// Compress the output with the codec,
+	// the level is codec specific, see Compress
+	Compress(codec Codec, level int) OutputConfigurationBuilder
 	AddProcessingStep(p Processor) OutputConfigurationBuilder
 	Build() Pipeline
 }
 
 type FanoutBuilder interface {
 	Register(func(output OutputBuilder) Pipeline) FanoutBuilder
+	// Send only the lines matching the predicate to the branch,
+	// use MatchJSON to match decoded records
+	Route(predicate RoutePredicate, branch func(output OutputBuilder) Pipeline) FanoutBuilder
+	// Send the lines no route matched to the branch
+	Default(branch func(output OutputBuilder) Pipeline) FanoutBuilder
+	// Decide what happens to the other branches once a branch failed,
+	// by default all branches are cancelled. Execute returns a FanoutError
+	// with the error of every branch, errors of the input before the
+	// fanout are returned as they are
+	OnBranchError(policy FanoutPolicy) FanoutBuilder
+	// Buffer up to memory bytes for every branch and spill up to disk
+	// further bytes into a temporary file, so slow branches do not stall
+	// the others until their buffer is full. Negative sizes count as zero,
+	// without any room the branches are fed synchronously. stats is called
+	// with the buffer usage of every branch once it completed, the calls
+	// come concurrently from the goroutines of the branches
+	Buffer(memory int64, disk int64, stats func(BufferStats)) FanoutBuilder
+	// Bundle the branches into a tar or zip archive, the files of all
+	// branches become members of it. Members are named after the files
+	// without volume and leading slashes, paths leaving the archive fail.
+	// FileOptions like Atomic or FileMode do not apply to members and are ignored
+	ToArchive(path string, format ArchiveFormat) FanoutBuilder
 	Build() Pipeline
 }
 
 type Pipeline interface {
 	Execute() error
+
+	// Execute the pipeline until it completes or ctx is done.
+	// Cancelling ctx aborts downloads and closes every
+	// pipe between the stages with ctx.Err(), it returns
+	// right away even if a read of the source blocks
+	ExecuteContext(ctx context.Context) error
 }
 
 type readerStep (func(next Reader) Reader)
-type consumeReaderWithSize func(next ReaderWithSize) error
+type consumeReaderWithSize func(ctx context.Context, next ReaderWithSize) error
 type consumeReader func(next Reader) error
 
 type connectorToReader func(next Connector) Reader
@@ -1,11 +1,20 @@
 package pipeline_test
 
 import (
+	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/paulheg/pipeline"
 	"github.com/stretchr/testify/assert"
@@ -382,3 +391,300 @@ func BenchmarkFunctional(b *testing.B) {
 				pipeline.ToWriter(&builder, pipeline.Copy)))
 	}
 }
+
+func TestExecuteContextCancel(t *testing.T) {
+	// the source never delivers any data
+	source, sourceWriter := io.Pipe()
+	defer sourceWriter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromReader(source, -1).
+		ParseLines(func(line string) ([]byte, error) {
+			return []byte(line), nil
+		}).
+		Fanout().
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&out).Build()
+		}).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ReadOnly().Build()
+		}).
+		Build().
+		ExecuteContext(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, out.String())
+}
+
+func TestExecuteContextCancelWithoutStages(t *testing.T) {
+	// the source never delivers any data
+	source, sourceWriter := io.Pipe()
+	defer sourceWriter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromReader(source, -1).
+		ToWriter(&out).
+		Build().
+		ExecuteContext(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, out.String())
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	path := filepath.Join(t.TempDir(), "out.txt")
+
+	err = pipeline.Build().
+		FromReader(source, -1).
+		DecompressGzip(true).
+		ToFile(path).
+		Build().
+		ExecuteContext(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFromWebContextCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first line\n"))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := pipeline.Build().
+		FromWeb(server.URL).
+		ParseLines(func(line string) ([]byte, error) {
+			return []byte(line), nil
+		}).
+		ReadOnly().
+		Build().
+		ExecuteContext(ctx)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestParseErrorIsReturned(t *testing.T) {
+	parseErr := errors.New("invalid line")
+	r := strings.NewReader("ok\nok\nfail\nok")
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		ParseLines(func(line string) ([]byte, error) {
+			if line == "fail" {
+				return nil, parseErr
+			}
+			return []byte(line), nil
+		}).
+		ReadOnly().
+		Build().
+		Execute()
+
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.ErrorIs(t, err, parseErr)
+	assert.Equal(t, "parse", stageErr.Stage)
+	assert.Equal(t, 3, stageErr.Line)
+	assert.Equal(t, int64(6), stageErr.Offset)
+}
+
+func TestEncoderParseErrorIsReturned(t *testing.T) {
+	parseErr := errors.New("invalid line")
+	r := strings.NewReader("ok\nfail")
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		ParseLinesToJson(func(line string) (interface{}, error) {
+			if line == "fail" {
+				return nil, parseErr
+			}
+			return line, nil
+		}).
+		Fanout().
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ReadOnly().Build()
+		}).
+		Build().
+		Execute()
+
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.ErrorIs(t, err, parseErr)
+	assert.Equal(t, 2, stageErr.Line)
+}
+
+func TestDecodeErrorIsReturned(t *testing.T) {
+	r := strings.NewReader(`{"Key":"Value"} {"Key":`)
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		Decode(pipeline.DecodeJson[map[string]string](func(m *map[string]string) []byte {
+			return []byte((*m)["Key"])
+		})).
+		ReadOnly().
+		Build().
+		Execute()
+
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.Equal(t, "decode", stageErr.Stage)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestOnParseError(t *testing.T) {
+	const input = "1\nx\n2\ny\n3"
+
+	parse := func(line string) ([]byte, error) {
+		if _, err := strconv.Atoi(line); err != nil {
+			return nil, err
+		}
+		return []byte(line), nil
+	}
+
+	t.Run("fail", func(t *testing.T) {
+		r := strings.NewReader(input)
+		var out strings.Builder
+
+		err := pipeline.Build().FromReader(r, r.Size()).
+			ParseLines(parse).
+			OnParseError(pipeline.FailOnParseError()).
+			ToWriter(&out).Build().Execute()
+
+		var stageErr *pipeline.StageError
+		assert.ErrorAs(t, err, &stageErr)
+		assert.Equal(t, 2, stageErr.Line)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		r := strings.NewReader(input)
+		var out strings.Builder
+		var skipped atomic.Int64
+
+		err := pipeline.Build().FromReader(r, r.Size()).
+			ParseLines(parse).
+			OnParseError(pipeline.SkipParseErrors(&skipped)).
+			ToWriter(&out).Build().Execute()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "123", out.String())
+		assert.Equal(t, int64(2), skipped.Load())
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		r := strings.NewReader(input)
+		var out strings.Builder
+		var dead strings.Builder
+
+		err := pipeline.Build().FromReader(r, r.Size()).
+			ParseLines(parse).
+			OnParseError(pipeline.DeadLetter(&dead)).
+			ToWriter(&out).Build().Execute()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "123", out.String())
+
+		lines := strings.Split(strings.TrimSpace(dead.String()), "\n")
+		assert.Len(t, lines, 2)
+
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+		assert.Equal(t, "y", record["raw"])
+		assert.Equal(t, float64(4), record["line"])
+		assert.Equal(t, float64(6), record["offset"])
+		assert.NotEmpty(t, record["error"])
+	})
+}
+
+func TestParallelParseLines(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+
+	parse := func(line string) ([]byte, error) {
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(n*2) + "\n"), nil
+	}
+
+	run := func(opts ...pipeline.StageOption) string {
+		r := strings.NewReader(input.String())
+		var out strings.Builder
+
+		err := pipeline.Build().FromReader(r, r.Size()).
+			ParseLines(parse, opts...).
+			ToWriter(&out).Build().Execute()
+		assert.NoError(t, err)
+
+		return out.String()
+	}
+
+	serial := run()
+
+	assert.Equal(t, serial, run(pipeline.Parallel(8, true)))
+
+	unordered := strings.Split(strings.TrimSpace(run(pipeline.Parallel(8, false))), "\n")
+	expected := strings.Split(strings.TrimSpace(serial), "\n")
+	assert.ElementsMatch(t, expected, unordered)
+}
+
+func TestParallelDecode(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+
+	run := func(opts ...pipeline.StageOption) string {
+		r := strings.NewReader(input.String())
+		var out strings.Builder
+
+		err := pipeline.Build().FromReader(r, r.Size()).
+			Decode(pipeline.DecodeJson[int](func(i *int) []byte {
+				return []byte(strconv.Itoa(*i+1) + ",")
+			}, opts...)).
+			ToWriter(&out).Build().Execute()
+		assert.NoError(t, err)
+
+		return out.String()
+	}
+
+	assert.Equal(t, run(), run(pipeline.Parallel(4, true)))
+}
+
+func TestParallelParseError(t *testing.T) {
+	r := strings.NewReader(strings.Repeat("1\n", 100) + "x\n" + strings.Repeat("1\n", 100))
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ParseLines(func(line string) ([]byte, error) {
+			_, err := strconv.Atoi(line)
+			return []byte(line), err
+		}, pipeline.Parallel(4, true)).
+		ReadOnly().Build().Execute()
+
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.Equal(t, 101, stageErr.Line)
+}
@@ -0,0 +1,182 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// HTTPCache stores downloads in dir, keyed by url.
+// A cached download is revalidated with If-None-Match and If-Modified-Since,
+// if the server answers with 304 Not Modified the body is read from disk.
+// To store the complete body, the rest of the download is read even if
+// the pipeline stopped reading early, so the whole file is always downloaded.
+func HTTPCache(dir string) HTTPOption {
+	return func(c *httpConfig) {
+		c.cache = &httpCache{dir: dir}
+	}
+}
+
+type httpCache struct {
+	dir string
+}
+
+// cacheEntry is stored next to the cached body.
+type cacheEntry struct {
+	URL             string `json:"url"`
+	ETag            string `json:"etag,omitempty"`
+	LastModified    string `json:"last_modified,omitempty"`
+	ContentType     string `json:"content_type,omitempty"`
+	ContentEncoding string `json:"content_encoding,omitempty"`
+}
+
+func (c *httpCache) paths(req *http.Request) (body string, meta string) {
+	hash := sha256.Sum256([]byte(req.URL.String()))
+	key := hex.EncodeToString(hash[:])
+
+	return filepath.Join(c.dir, key+".body"), filepath.Join(c.dir, key+".json")
+}
+
+// prepare makes the request conditional if the url is cached
+// and returns the cached entry.
+func (c *httpCache) prepare(req *http.Request) *cacheEntry {
+	body, meta := c.paths(req)
+
+	if _, err := os.Stat(body); err != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(meta)
+	if err != nil {
+		return nil
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil || entry.URL != req.URL.String() {
+		return nil
+	}
+
+	if entry.ETag != "" {
+		req.Header.Set("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.LastModified)
+	}
+
+	return entry
+}
+
+// serve reads the cached body of the request.
+func (c *httpCache) serve(req *http.Request, entry *cacheEntry, next ReaderWithSize) error {
+	path, _ := c.paths(req)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stats, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	ctx := withSource(req.Context(), source{
+		name:            req.URL.Path,
+		contentEncoding: entry.ContentEncoding,
+		contentType:     entry.ContentType,
+	})
+
+	return next(WithContext(ctx, file), stats.Size())
+}
+
+// record copies the body into the cache while it is read.
+// The entry is only stored once commit is called,
+// discard removes the partial copy.
+func (c *httpCache) record(req *http.Request, resp *http.Response, body io.Reader) (*cacheRecorder, error) {
+	if err := os.MkdirAll(c.dir, 0777); err != nil {
+		return nil, err
+	}
+
+	temp, err := os.CreateTemp(c.dir, "download-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &cacheRecorder{
+		Reader: io.TeeReader(body, temp),
+		cache:  c,
+		req:    req,
+		temp:   temp,
+		entry: cacheEntry{
+			URL:             req.URL.String(),
+			ETag:            resp.Header.Get("ETag"),
+			LastModified:    resp.Header.Get("Last-Modified"),
+			ContentType:     resp.Header.Get("Content-Type"),
+			ContentEncoding: resp.Header.Get("Content-Encoding"),
+		},
+	}, nil
+}
+
+type cacheRecorder struct {
+	io.Reader
+
+	cache *httpCache
+	req   *http.Request
+	temp  *os.File
+	entry cacheEntry
+}
+
+func (r *cacheRecorder) commit() error {
+	// store the rest of the body, even if it was not read downstream
+	if _, err := io.Copy(io.Discard, r.Reader); err != nil {
+		r.discard()
+		return err
+	}
+
+	if err := r.temp.Close(); err != nil {
+		r.discard()
+		return err
+	}
+
+	meta, err := json.Marshal(r.entry)
+	if err != nil {
+		r.discard()
+		return err
+	}
+
+	body, metaPath := r.cache.paths(r.req)
+
+	// the metadata of the old body must never validate the new one,
+	// so it is removed first and written once the new body is in place
+	if err := os.Remove(metaPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		r.discard()
+		return err
+	}
+
+	if err := os.Rename(r.temp.Name(), body); err != nil {
+		r.discard()
+		return err
+	}
+
+	return writeFileAtomic(metaPath, meta)
+}
+
+// writeFileAtomic writes data into a temporary file and renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	return newFileConfig(nil).writeAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+func (r *cacheRecorder) discard() {
+	r.temp.Close()
+	os.Remove(r.temp.Name())
+}
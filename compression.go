@@ -0,0 +1,326 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec is a compression format of a stream.
+type Codec int
+
+const (
+	NoCompression Codec = iota
+	Gzip
+	Zstd
+	Snappy
+	S2
+	// bzip2 is only supported for decompression
+	Bzip2
+	Zlib
+	Deflate
+	// the members of a zip archive are decompressed
+	// one after another into a single stream
+	Zip
+	// xz is only detected, but not supported
+	Xz
+)
+
+// DefaultLevel selects the default compression level of a codec.
+const DefaultLevel = -1
+
+func (c Codec) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	case S2:
+		return "s2"
+	case Bzip2:
+		return "bzip2"
+	case Zlib:
+		return "zlib"
+	case Deflate:
+		return "deflate"
+	case Zip:
+		return "zip"
+	case Xz:
+		return "xz"
+	}
+
+	return fmt.Sprintf("Codec(%d)", int(c))
+}
+
+// Decompress decompresses the stream with the codec.
+func Decompress(codec Codec, next Reader) Reader {
+	if codec == Gzip {
+		return DecompressGzip(next)
+	}
+
+	return func(r io.Reader) error {
+		dr, err := newDecompressor(codec, r)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+
+		return next(inheritContext(r, dr))
+	}
+}
+
+// Compress compresses the stream with the codec.
+// The level is codec specific, DefaultLevel selects the default of the codec.
+// For zstd the levels of the zstd command line tool are used,
+// for s2 1 is the fastest and 3 the best compression.
+func Compress(codec Codec, level int, next Connector) Connector {
+	if codec == Gzip {
+		return CompressGzip(next, GzipLevel(level))
+	}
+
+	return func(w io.Writer, r io.Reader) error {
+		cw, err := newCompressor(codec, level, w)
+		if err != nil {
+			return err
+		}
+
+		err = next(cw, r)
+		if cerr := cw.Close(); err == nil {
+			err = cerr
+		}
+
+		return err
+	}
+}
+
+func newDecompressor(codec Codec, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case NoCompression:
+		return io.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case Snappy, S2:
+		// the s2 reader also reads the snappy framing format
+		return io.NopCloser(s2.NewReader(r)), nil
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case Zlib:
+		return zlib.NewReader(r)
+	case Deflate:
+		return flate.NewReader(r), nil
+	case Zip:
+		return newZipReader(r)
+	}
+
+	return nil, fmt.Errorf("decompress: unsupported codec %v", codec)
+}
+
+func newCompressor(codec Codec, level int, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case NoCompression:
+		return nopWriteCloser{w}, nil
+	case Zstd:
+		zstdLevel := zstd.SpeedDefault
+		if level != DefaultLevel {
+			zstdLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel))
+	case Snappy:
+		return s2.NewWriter(w, s2.WriterSnappyCompat()), nil
+	case S2:
+		switch {
+		case level == 2:
+			return s2.NewWriter(w, s2.WriterBetterCompression()), nil
+		case level >= 3:
+			return s2.NewWriter(w, s2.WriterBestCompression()), nil
+		}
+		return s2.NewWriter(w), nil
+	case Zlib:
+		return zlib.NewWriterLevel(w, level)
+	case Deflate:
+		return flate.NewWriter(w, level)
+	}
+
+	return nil, fmt.Errorf("compress: unsupported codec %v", codec)
+}
+
+// DecompressAuto detects the compression of the stream from its first bytes.
+// If the stream has no known signature, the extension of the file or url and
+// the Content-Encoding or Content-Type of a download are used as a fallback.
+// Formats with a signature are only decompressed if the signature matches,
+// so a mislabeled uncompressed file is passed through as is.
+func DecompressAuto(next Reader) Reader {
+	return func(r io.Reader) error {
+		dr, err := newAutoDecompressor(r, sourceOf(r))
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+
+		return next(inheritContext(r, dr))
+	}
+}
+
+// newAutoDecompressor detects the codec of r, see DecompressAuto.
+func newAutoDecompressor(r io.Reader, s source) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.Peek(16)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	codec := detectCodec(magic)
+	if codec == NoCompression {
+		codec = codecOfSource(s)
+	}
+
+	return newDecompressor(codec, br)
+}
+
+var signatures = []struct {
+	magic []byte
+	codec Codec
+}{
+	{[]byte{0x1f, 0x8b}, Gzip},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, Zstd},
+	{[]byte("BZh"), Bzip2},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, Xz},
+	{[]byte("PK\x03\x04"), Zip},
+	{[]byte("\xff\x06\x00\x00sNaPpY"), Snappy},
+	{[]byte("\xff\x06\x00\x00S2sTwO"), S2},
+	{[]byte{0x78, 0x01}, Zlib},
+	{[]byte{0x78, 0x9c}, Zlib},
+	{[]byte{0x78, 0xda}, Zlib},
+}
+
+func detectCodec(magic []byte) Codec {
+	for _, s := range signatures {
+		if bytes.HasPrefix(magic, s.magic) {
+			return s.codec
+		}
+	}
+
+	return NoCompression
+}
+
+// codecOfSource guesses the codec from the source of the stream.
+// Only codecs without a signature are returned,
+// all others are detected by detectCodec.
+func codecOfSource(s source) Codec {
+	if strings.EqualFold(s.contentEncoding, "deflate") {
+		// the http deflate encoding is a zlib stream
+		return Zlib
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(s.contentType); mediaType == "application/zlib" {
+		return Zlib
+	}
+
+	switch strings.ToLower(path.Ext(s.name)) {
+	case ".zz", ".zlib":
+		return Zlib
+	case ".deflate":
+		return Deflate
+	}
+
+	return NoCompression
+}
+
+// zipReader reads all files of a zip archive as a single stream.
+// Zip archives can not be read sequentially,
+// so the stream is spooled into a temporary file first.
+type zipReader struct {
+	io.Reader
+	file *os.File
+}
+
+func newZipReader(r io.Reader) (io.ReadCloser, error) {
+	file, err := os.CreateTemp("", "pipeline-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	z := &zipReader{file: file}
+
+	size, err := io.Copy(file, r)
+	if err != nil {
+		z.Close()
+		return nil, err
+	}
+
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		z.Close()
+		return nil, err
+	}
+
+	readers := make([]io.Reader, 0, len(archive.File))
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		readers = append(readers, &zipMember{file: f})
+	}
+	z.Reader = io.MultiReader(readers...)
+
+	return z, nil
+}
+
+func (z *zipReader) Close() error {
+	z.file.Close()
+	return os.Remove(z.file.Name())
+}
+
+// zipMember opens the file of the archive on the first read.
+type zipMember struct {
+	file *zip.File
+	rc   io.ReadCloser
+}
+
+func (m *zipMember) Read(p []byte) (int, error) {
+	if m.rc == nil {
+		rc, err := m.file.Open()
+		if err != nil {
+			return 0, err
+		}
+		m.rc = rc
+	}
+
+	n, err := m.rc.Read(p)
+	if err == io.EOF {
+		m.rc.Close()
+	}
+
+	return n, err
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,263 @@
+package pipeline_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	text := strings.Repeat("hello compression\n", 1000)
+
+	testCases := []struct {
+		codec pipeline.Codec
+		level int
+	}{
+		{pipeline.Gzip, pipeline.DefaultLevel},
+		{pipeline.Gzip, 9},
+		{pipeline.Zstd, pipeline.DefaultLevel},
+		{pipeline.Zstd, 19},
+		{pipeline.Snappy, pipeline.DefaultLevel},
+		{pipeline.S2, 3},
+		{pipeline.Zlib, 1},
+		{pipeline.Deflate, pipeline.DefaultLevel},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.codec.String(), func(t *testing.T) {
+			r := strings.NewReader(text)
+			var compressed bytes.Buffer
+
+			err := pipeline.Build().FromReader(r, r.Size()).
+				ToWriter(&compressed).
+				Compress(tC.codec, tC.level).
+				Build().Execute()
+			assert.NoError(t, err)
+			assert.Less(t, compressed.Len(), len(text))
+
+			var out strings.Builder
+			err = pipeline.Build().FromReader(&compressed, int64(compressed.Len())).
+				Decompress(tC.codec).
+				ToWriter(&out).
+				Build().Execute()
+
+			assert.NoError(t, err)
+			assert.Equal(t, text, out.String())
+		})
+	}
+}
+
+func TestDecompressBzip2(t *testing.T) {
+	compressed, _ := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWY7Y+BoAAALJgAAQEAASZMAQIAAxADAgBp6inq2BDB4u5IpwoSEdsfA0")
+	r := bytes.NewReader(compressed)
+	var out strings.Builder
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		Decompress(pipeline.Bzip2).
+		ToWriter(&out).
+		Build().Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\nbzip2\n", out.String())
+}
+
+func TestCompressBzip2Unsupported(t *testing.T) {
+	r := strings.NewReader("hello")
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToWriter(&bytes.Buffer{}).
+		Compress(pipeline.Bzip2, pipeline.DefaultLevel).
+		Build().Execute()
+
+	assert.Error(t, err)
+}
+
+func TestDecompressAuto(t *testing.T) {
+	const text = "hello auto detection\n"
+
+	compress := func(codec pipeline.Codec) []byte {
+		r := strings.NewReader(text)
+		var b bytes.Buffer
+		err := pipeline.Build().FromReader(r, r.Size()).
+			ToWriter(&b).Compress(codec, pipeline.DefaultLevel).
+			Build().Execute()
+		assert.NoError(t, err)
+		return b.Bytes()
+	}
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(text))
+	}
+	zw.Close()
+
+	bz2, _ := base64.StdEncoding.DecodeString("QlpoOTFBWSZTWY7Y+BoAAALJgAAQEAASZMAQIAAxADAgBp6inq2BDB4u5IpwoSEdsfA0")
+
+	testCases := []struct {
+		desc     string
+		input    []byte
+		expected string
+	}{
+		{"plain", []byte(text), text},
+		{"gzip", compress(pipeline.Gzip), text},
+		{"zstd", compress(pipeline.Zstd), text},
+		{"s2", compress(pipeline.S2), text},
+		{"zlib", compress(pipeline.Zlib), text},
+		{"bzip2", bz2, "hello\nbzip2\n"},
+		{"zip", zipped.Bytes(), text + text},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			r := bytes.NewReader(tC.input)
+			var out strings.Builder
+
+			err := pipeline.Build().FromReader(r, r.Size()).
+				DecompressAuto().
+				ToWriter(&out).
+				Build().Execute()
+
+			assert.NoError(t, err)
+			assert.Equal(t, tC.expected, out.String())
+		})
+	}
+}
+
+func TestDecompressAutoFromExtension(t *testing.T) {
+	const text = "raw deflate has no signature"
+
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.DefaultCompression)
+	fw.Write([]byte(text))
+	fw.Close()
+
+	path := filepath.Join(t.TempDir(), "input.deflate")
+	assert.NoError(t, os.WriteFile(path, compressed.Bytes(), 0666))
+
+	var out strings.Builder
+	err := pipeline.Build().FromFile(path).
+		DecompressAuto().
+		ToWriter(&out).
+		Build().Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, text, out.String())
+}
+
+func TestDecompressAutoXzUnsupported(t *testing.T) {
+	r := bytes.NewReader([]byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00, 0x04})
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		DecompressAuto().
+		ReadOnly().
+		Build().Execute()
+
+	assert.ErrorContains(t, err, "xz")
+}
+
+func TestCompressGzipOptions(t *testing.T) {
+	text := strings.Repeat("0123456789", 1000)
+
+	testCases := []struct {
+		desc  string
+		input string
+		opts  []pipeline.GzipOption
+	}{
+		{"huffman only", text, []pipeline.GzipOption{pipeline.GzipLevel(gzip.HuffmanOnly)}},
+		{"best speed", text, []pipeline.GzipOption{pipeline.GzipLevel(gzip.BestSpeed)}},
+		{"concurrent", text, []pipeline.GzipOption{pipeline.GzipConcurrency(4, 1000)}},
+		{"concurrent empty", "", []pipeline.GzipOption{pipeline.GzipConcurrency(4, 1000)}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			r := strings.NewReader(tC.input)
+			var compressed bytes.Buffer
+
+			err := pipeline.Build().FromReader(r, r.Size()).
+				ToWriter(&compressed).
+				CompressGzip(true, tC.opts...).
+				Build().Execute()
+			assert.NoError(t, err)
+
+			var out strings.Builder
+			err = pipeline.Build().FromReader(&compressed, int64(compressed.Len())).
+				DecompressGzip(true).
+				ToWriter(&out).
+				Build().Execute()
+
+			assert.NoError(t, err)
+			assert.Equal(t, tC.input, out.String())
+		})
+	}
+}
+
+func TestDecompressGzipFirstMemberOnly(t *testing.T) {
+	text := strings.Repeat("0123456789", 100)
+
+	r := strings.NewReader(text)
+	var compressed bytes.Buffer
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToWriter(&compressed).
+		CompressGzip(true, pipeline.GzipConcurrency(2, 100)).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	var out strings.Builder
+	err = pipeline.Build().FromReader(&compressed, int64(compressed.Len())).
+		DecompressGzip(true, pipeline.GzipMultistream(false)).
+		ToWriter(&out).
+		Build().Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, text[:100], out.String())
+}
+
+// failingWriter fails every write after the first n.
+type failingWriter struct {
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, w.err
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestCompressGzipConcurrentWriteError(t *testing.T) {
+	text := strings.Repeat("0123456789", 1000)
+	failure := errors.New("failure")
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 50; i++ {
+		r := strings.NewReader(text)
+		err := pipeline.Build().FromReader(r, r.Size()).
+			ToWriter(&failingWriter{n: 1, err: failure}).
+			CompressGzip(true, pipeline.GzipConcurrency(4, 1000)).
+			Build().Execute()
+		assert.ErrorIs(t, err, failure)
+	}
+
+	// the workers of the failed executions stop
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
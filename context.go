@@ -0,0 +1,137 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader binds a context to a stream, so stages further
+// down the chain can find the context of the current execution.
+// Reads fail with the context error once the context is done.
+type contextReader struct {
+	io.Reader
+	ctx context.Context
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.Reader.Read(p)
+}
+
+// WithContext binds ctx to the reader.
+// Stages like ParseLine, Decode, Transcode and MultiProcess
+// tear down their pipes once the bound context is done.
+func WithContext(ctx context.Context, r io.Reader) io.Reader {
+	if ctx == context.Background() {
+		return r
+	}
+
+	if c, ok := r.(*contextReader); ok {
+		r = c.Reader
+	}
+
+	return &contextReader{Reader: r, ctx: ctx}
+}
+
+// ContextOf returns the context bound to the reader
+// or context.Background if there is none.
+func ContextOf(r io.Reader) context.Context {
+	if c, ok := r.(*contextReader); ok {
+		return c.ctx
+	}
+
+	return context.Background()
+}
+
+// inheritContext binds the context of parent to r.
+// It is used by stages that wrap the reader they received.
+func inheritContext(parent io.Reader, r io.Reader) io.Reader {
+	return WithContext(ContextOf(parent), r)
+}
+
+// pipeTo runs produce in its own goroutine and hands the produced
+// stream to next. The pipe is closed with the context error when the
+// context bound to r is done and as soon as next returns, so the
+// producer never outlives the stage.
+func pipeTo(r io.Reader, next Reader, produce func(w io.Writer) error) error {
+	ctx := ContextOf(r)
+	reader, writer := io.Pipe()
+
+	stop := context.AfterFunc(ctx, func() {
+		writer.CloseWithError(ctx.Err())
+	})
+	defer stop()
+
+	go func() {
+		writer.CloseWithError(produce(writer))
+	}()
+
+	err := next(WithContext(ctx, reader))
+	reader.CloseWithError(io.ErrClosedPipe)
+
+	return err
+}
+
+// cancelable hands the stream to next through a pipe if the context
+// bound to r can be cancelled, so next returns with the context error
+// once the context is done, even while a read of r blocks.
+// A blocked read keeps its goroutine until it returns.
+func cancelable(next Reader) Reader {
+	return func(r io.Reader) error {
+		ctx := ContextOf(r)
+		if ctx.Done() == nil {
+			return next(r)
+		}
+
+		reader, writer := io.Pipe()
+
+		stop := context.AfterFunc(ctx, func() {
+			writer.CloseWithError(ctx.Err())
+		})
+		defer stop()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, err := io.Copy(writer, r)
+			writer.CloseWithError(err)
+		}()
+
+		err := next(WithContext(ctx, reader))
+		reader.CloseWithError(io.ErrClosedPipe)
+
+		// the source may be used once next returned,
+		// so wait for the copy unless it cannot be interrupted
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+
+		return err
+	}
+}
+
+type sourceKey struct{}
+
+// source describes where the stream of an execution comes from,
+// stages use it as a hint how to interpret the stream.
+type source struct {
+	// path or url of the input
+	name string
+
+	contentEncoding string
+	contentType     string
+}
+
+func withSource(ctx context.Context, s source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, s)
+}
+
+// sourceOf returns the source of the stream read by r.
+func sourceOf(r io.Reader) source {
+	s, _ := ContextOf(r).Value(sourceKey{}).(source)
+	return s
+}
@@ -0,0 +1,406 @@
+package pipeline
+
+import (
+	"encoding"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CSVOption configures the csv decoder and encoder.
+type CSVOption func(*csvConfig)
+
+type csvConfig struct {
+	comma   rune
+	comment rune
+	header  bool
+}
+
+func newCSVConfig(opts []CSVOption) *csvConfig {
+	c := &csvConfig{
+		comma:  ',',
+		header: true,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// CSVDelimiter sets the field delimiter, use '\t' for tsv.
+func CSVDelimiter(delimiter rune) CSVOption {
+	return func(c *csvConfig) {
+		c.comma = delimiter
+	}
+}
+
+// CSVComment sets the character that starts a comment line.
+func CSVComment(comment rune) CSVOption {
+	return func(c *csvConfig) {
+		c.comment = comment
+	}
+}
+
+// CSVHeader sets if the first row is a header, which is the default.
+// With a header columns are mapped to struct fields by name,
+// without a header by the order of the fields.
+func CSVHeader(header bool) CSVOption {
+	return func(c *csvConfig) {
+		c.header = header
+	}
+}
+
+// ParseCSV decodes csv rows into T and encodes them with the encoder.
+// Use it with InputBuilder.Decode.
+//
+// Columns are mapped to the fields of T with the `csv:"name"` tag,
+// fields without a tag use their name and fields tagged with "-" are skipped.
+func ParseCSV[T any](encoder NewEncoder, opts ...CSVOption) Processor {
+	return Transcode(NewCSVDecoder(opts...), encoder, func(record *T) any {
+		return record
+	})
+}
+
+// EncodeCSV decodes records of type I with the decoder and writes them as csv rows.
+func EncodeCSV[I any](decoder NewDecoder, opts ...CSVOption) Processor {
+	return Transcode(decoder, NewCSVEncoder(opts...), func(record *I) any {
+		return record
+	})
+}
+
+// NewCSVDecoder returns a NewDecoder that decodes csv rows
+// into structs, *[]string or *map[string]string.
+func NewCSVDecoder(opts ...CSVOption) NewDecoder {
+	config := newCSVConfig(opts)
+
+	return func(r io.Reader) Decoder {
+		reader := csv.NewReader(r)
+		reader.Comma = config.comma
+		reader.Comment = config.comment
+		reader.FieldsPerRecord = -1
+		reader.ReuseRecord = true
+
+		return &csvDecoder{
+			config: config,
+			reader: reader,
+			fields: make(map[reflect.Type][]int),
+		}
+	}
+}
+
+// NewCSVEncoder returns a NewEncoder that writes structs,
+// []string or map[string]string as csv rows. The columns of maps are the
+// sorted keys of the first map, later maps with other keys fail.
+// Rows are buffered and written once the buffer is full or the stage ends.
+func NewCSVEncoder(opts ...CSVOption) NewEncoder {
+	config := newCSVConfig(opts)
+
+	return func(w io.Writer) Encoder {
+		writer := csv.NewWriter(w)
+		writer.Comma = config.comma
+
+		return &csvEncoder{
+			config: config,
+			writer: writer,
+		}
+	}
+}
+
+type csvDecoder struct {
+	config *csvConfig
+	reader *csv.Reader
+
+	header []string
+	// column index for every field of a struct type,
+	// -1 if the field has no column
+	fields map[reflect.Type][]int
+}
+
+func (d *csvDecoder) Decode(e any) error {
+	if d.config.header && d.header == nil {
+		header, err := d.reader.Read()
+		if err != nil {
+			return err
+		}
+		d.header = append([]string{}, header...)
+	}
+
+	record, err := d.reader.Read()
+	if err != nil {
+		return err
+	}
+
+	switch v := e.(type) {
+	case *[]string:
+		*v = append((*v)[:0], record...)
+		return nil
+	case *map[string]string:
+		if d.header == nil {
+			return fmt.Errorf("csv: decoding into a map requires a header")
+		}
+		m := make(map[string]string, len(record))
+		for i, value := range record {
+			if i < len(d.header) {
+				m[d.header[i]] = value
+			}
+		}
+		*v = m
+		return nil
+	}
+
+	value := reflect.ValueOf(e)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("csv: cannot decode into %T", e)
+	}
+	value = value.Elem()
+
+	columns, ok := d.fields[value.Type()]
+	if !ok {
+		columns = d.columns(value.Type())
+		d.fields[value.Type()] = columns
+	}
+
+	for field, column := range columns {
+		if column < 0 || column >= len(record) {
+			continue
+		}
+
+		if err := setCSVField(value.Field(field), record[column]); err != nil {
+			line, col := d.reader.FieldPos(column)
+			return &StageError{
+				Stage:  "decode",
+				Line:   line,
+				Offset: d.reader.InputOffset(),
+				Err:    fmt.Errorf("csv: column %d of field %s: %w", col, value.Type().Field(field).Name, err),
+			}
+		}
+	}
+
+	return nil
+}
+
+// columns maps the fields of t to the columns of the csv.
+func (d *csvDecoder) columns(t reflect.Type) []int {
+	columns := make([]int, t.NumField())
+	position := 0
+
+	for i := range columns {
+		columns[i] = -1
+
+		name, ok := csvFieldName(t.Field(i))
+		if !ok {
+			continue
+		}
+
+		if d.header == nil {
+			columns[i] = position
+			position++
+			continue
+		}
+
+		for j, column := range d.header {
+			if column == name {
+				columns[i] = j
+				break
+			}
+			if columns[i] < 0 && strings.EqualFold(column, name) {
+				columns[i] = j
+			}
+		}
+	}
+
+	return columns
+}
+
+type csvEncoder struct {
+	config *csvConfig
+	writer *csv.Writer
+
+	wroteHeader bool
+	row         []string
+
+	// columns of maps, set by the first map
+	columns []string
+}
+
+func (e *csvEncoder) Encode(v any) error {
+	switch record := v.(type) {
+	case []string:
+		return e.write(nil, record)
+	case *[]string:
+		return e.write(nil, *record)
+	case map[string]string:
+		return e.encodeMap(record)
+	case *map[string]string:
+		return e.encodeMap(*record)
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("csv: cannot encode %T", v)
+	}
+
+	var header []string
+	e.row = e.row[:0]
+
+	for i := 0; i < value.NumField(); i++ {
+		name, ok := csvFieldName(value.Type().Field(i))
+		if !ok {
+			continue
+		}
+
+		field, err := formatCSVField(value.Field(i))
+		if err != nil {
+			return err
+		}
+
+		header = append(header, name)
+		e.row = append(e.row, field)
+	}
+
+	return e.write(header, e.row)
+}
+
+func (e *csvEncoder) encodeMap(record map[string]string) error {
+	if e.columns == nil {
+		e.columns = make([]string, 0, len(record))
+		for key := range record {
+			e.columns = append(e.columns, key)
+		}
+		slices.Sort(e.columns)
+	}
+
+	e.row = e.row[:0]
+	for _, column := range e.columns {
+		e.row = append(e.row, record[column])
+	}
+
+	for key := range record {
+		if !slices.Contains(e.columns, key) {
+			return fmt.Errorf("csv: column %q is not in the header", key)
+		}
+	}
+
+	return e.write(e.columns, e.row)
+}
+
+func (e *csvEncoder) write(header []string, row []string) error {
+	if e.config.header && !e.wroteHeader && header != nil {
+		if err := e.writer.Write(header); err != nil {
+			return err
+		}
+	}
+	e.wroteHeader = true
+
+	return e.writer.Write(row)
+}
+
+// Flush writes the buffered rows.
+func (e *csvEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// csvFieldName returns the column name of a struct field
+// and false if the field is not mapped to a column.
+func csvFieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+
+	tag, _, _ := strings.Cut(field.Tag.Get("csv"), ",")
+	switch tag {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	}
+
+	return tag, true
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+func setCSVField(field reflect.Value, value string) error {
+	if field.Kind() == reflect.Pointer {
+		if value == "" {
+			field.SetZero()
+			return nil
+		}
+		field.Set(reflect.New(field.Type().Elem()))
+		field = field.Elem()
+	}
+
+	if field.Addr().Type().Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return nil
+}
+
+func formatCSVField(field reflect.Value) (string, error) {
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return "", nil
+		}
+		field = field.Elem()
+	}
+
+	if field.Type().Implements(textMarshalerType) {
+		text, err := field.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		return field.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(field.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(field.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'g', -1, field.Type().Bits()), nil
+	}
+
+	return "", fmt.Errorf("csv: unsupported type %s", field.Type())
+}
@@ -0,0 +1,141 @@
+package pipeline_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+type csvRow struct {
+	Name    string  `csv:"name"`
+	City    string  `csv:"city"`
+	Age     int     `csv:"age"`
+	Score   float64 `csv:"score"`
+	Ignored string  `csv:"-"`
+}
+
+func TestParseCSV(t *testing.T) {
+	const input = `name,age,city,score
+# a comment
+"Doe, Jane",42,"Berlin",1.5
+John,7,"New ""York""",2
+`
+	const expected = `{"Name":"Doe, Jane","City":"Berlin","Age":42,"Score":1.5,"Ignored":""}
+{"Name":"John","City":"New \"York\"","Age":7,"Score":2,"Ignored":""}
+`
+
+	r := strings.NewReader(input)
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		Decode(pipeline.ParseCSV[csvRow](newJsonEncoder, pipeline.CSVComment('#'))).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, out.String())
+}
+
+func TestParseTSVWithoutHeader(t *testing.T) {
+	const input = "Jane\tBerlin\t42\t1.5\n"
+
+	r := strings.NewReader(input)
+	var rows []csvRow
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		Decode(pipeline.Decode(pipeline.NewCSVDecoder(pipeline.CSVDelimiter('\t'), pipeline.CSVHeader(false)), func(row *csvRow) []byte {
+			rows = append(rows, *row)
+			return nil
+		})).
+		ReadOnly().
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []csvRow{{Name: "Jane", City: "Berlin", Age: 42, Score: 1.5}}, rows)
+}
+
+func TestParseCSVInvalidField(t *testing.T) {
+	r := strings.NewReader("name,age\nJane,42\nJohn,old\n")
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		Decode(pipeline.ParseCSV[csvRow](newJsonEncoder)).
+		ReadOnly().
+		Build().
+		Execute()
+
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.Equal(t, 3, stageErr.Line)
+}
+
+func TestEncodeCSV(t *testing.T) {
+	r := strings.NewReader(`{"Name":"Doe, Jane","City":"Berlin","Age":42,"Score":1.5}`)
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		ToWriter(&out).
+		AddProcessingStep(pipeline.EncodeCSV[csvRow](newJsonDecoder, pipeline.CSVDelimiter(';'))).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "name;city;age;score\nDoe, Jane;Berlin;42;1.5\n", out.String())
+}
+
+func TestEncodeCSVMap(t *testing.T) {
+	r := strings.NewReader(`{"name":"Jane","city":"Berlin"}{"city":"Paris","name":"John"}{"name":"Max"}`)
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		ToWriter(&out).
+		AddProcessingStep(pipeline.EncodeCSV[map[string]string](newJsonDecoder)).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "city,name\nBerlin,Jane\nParis,John\n,Max\n", out.String())
+
+	r = strings.NewReader(`{"name":"Jane"}{"name":"John","city":"Paris"}`)
+	out.Reset()
+	err = pipeline.Build().
+		FromReader(r, r.Size()).
+		ToWriter(&out).
+		AddProcessingStep(pipeline.EncodeCSV[map[string]string](newJsonDecoder)).
+		Build().
+		Execute()
+
+	assert.ErrorContains(t, err, `column "city" is not in the header`)
+}
+
+func TestEncodeCSVBuffered(t *testing.T) {
+	r := strings.NewReader(strings.Repeat(`{"Name":"Jane","City":"Berlin","Age":42,"Score":1.5}`, 100))
+	writes := 0
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		ToWriter(writerFunc(func(p []byte) (int, error) {
+			writes++
+			return len(p), nil
+		})).
+		AddProcessingStep(pipeline.EncodeCSV[csvRow](newJsonDecoder)).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, writes)
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
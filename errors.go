@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// StageError is returned by a pipeline when one of its stages fails
+// to parse, decode or encode the stream.
+type StageError struct {
+	// Name of the failing stage, e.g. "parse", "decode" or "encode"
+	Stage string
+	// 1-based line number, zero for stages that are not line oriented
+	Line int
+	// Byte offset in the input of the stage,
+	// for line oriented stages the start of the line
+	Offset int64
+
+	Err error
+}
+
+func (e *StageError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s: line %d (offset %d): %v", e.Stage, e.Line, e.Offset, e.Err)
+	}
+
+	return fmt.Sprintf("%s: offset %d: %v", e.Stage, e.Offset, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// ErrLineTooLong is reported for lines longer than MaxLineSize.
+var ErrLineTooLong = errors.New("pipeline: line too long")
+
+// lineScanner is a bufio.Scanner that keeps track of
+// the line number and the offset of the current line.
+type lineScanner struct {
+	*bufio.Scanner
+
+	line   int
+	offset int64
+	next   int64
+}
+
+func newLineScanner(r io.Reader) *lineScanner {
+	s := &lineScanner{
+		Scanner: bufio.NewScanner(r),
+	}
+
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			s.line++
+			s.offset = s.next
+			s.next += int64(advance)
+		}
+
+		return advance, token, err
+	})
+
+	return s
+}
+
+// scannedLine is a line together with its position in the input.
+type scannedLine struct {
+	text   string
+	line   int
+	offset int64
+}
+
+// read returns the next line, it matches the read function of parallel.
+func (s *lineScanner) read() (scannedLine, bool, error) {
+	if !s.Scan() {
+		return scannedLine{}, false, s.Err()
+	}
+
+	return scannedLine{
+		text:   s.Text(),
+		line:   s.line,
+		offset: s.offset,
+	}, true, nil
+}
+
+// error wraps err into a StageError for the line.
+func (l scannedLine) error(stage string, err error) *StageError {
+	return &StageError{
+		Stage:  stage,
+		Line:   l.line,
+		Offset: l.offset,
+		Err:    err,
+	}
+}
+
+// Err returns the error of the underlying scanner wrapped
+// into a StageError, errors of the reader are returned as is.
+func (s *lineScanner) Err() error {
+	err := s.Scanner.Err()
+	if err == bufio.ErrTooLong {
+		return &StageError{
+			Stage:  "parse",
+			Line:   s.line + 1,
+			Offset: s.next,
+			Err:    err,
+		}
+	}
+
+	return err
+}
+
+// countingReader counts the bytes read from the reader,
+// it is used to report offsets of decoding errors.
+type countingReader struct {
+	io.Reader
+	n   int64
+	err error
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	if err != nil && err != io.EOF {
+		c.err = err
+	}
+	return n, err
+}
+
+// error wraps err into a StageError at the current offset.
+// Errors of the underlying reader are passed through,
+// they already originate from an earlier stage.
+func (c *countingReader) error(stage string, err error) error {
+	if c.err != nil && err == c.err {
+		return err
+	}
+
+	// the decoder already knows where it failed
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		return err
+	}
+
+	return &StageError{
+		Stage:  stage,
+		Offset: c.n,
+		Err:    err,
+	}
+}
+
+// ParseErrorPolicy decides what happens to a line its LineParser rejected.
+// Returning nil skips the line, returning an error fails the pipeline.
+type ParseErrorPolicy func(line string, err *StageError) error
+
+// FailOnParseError fails the pipeline on the first rejected line.
+// This is the default policy.
+func FailOnParseError() ParseErrorPolicy {
+	return func(line string, err *StageError) error {
+		return err
+	}
+}
+
+// SkipParseErrors skips rejected lines.
+// If skipped is not nil it counts the skipped lines.
+func SkipParseErrors(skipped *atomic.Int64) ParseErrorPolicy {
+	return func(line string, err *StageError) error {
+		if skipped != nil {
+			skipped.Add(1)
+		}
+		return nil
+	}
+}
+
+// DeadLetter skips rejected lines and writes them to w,
+// one JSON object per line containing the raw line,
+// its position and the parser error.
+func DeadLetter(w io.Writer) ParseErrorPolicy {
+	enc := json.NewEncoder(w)
+
+	return func(line string, err *StageError) error {
+		return enc.Encode(struct {
+			Line   int    `json:"line"`
+			Offset int64  `json:"offset"`
+			Error  string `json:"error"`
+			Raw    string `json:"raw"`
+		}{
+			Line:   err.Line,
+			Offset: err.Offset,
+			Error:  err.Err.Error(),
+			Raw:    line,
+		})
+	}
+}
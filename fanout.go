@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// FanoutPolicy decides what happens to the other branches
+// of a fanout once a branch failed.
+type FanoutPolicy int
+
+const (
+	// FailAll cancels the other branches with ErrFanoutCancelled
+	// once a branch failed, it is the default.
+	FailAll FanoutPolicy = iota
+	// Isolate detaches the failed branch
+	// and keeps feeding the other branches.
+	Isolate
+)
+
+// ErrFanoutCancelled is reported for branches cancelled
+// because another branch of the fanout failed.
+var ErrFanoutCancelled = errors.New("pipeline: fanout branch cancelled after another branch failed")
+
+// FanoutError reports the errors of the branches of a fanout.
+type FanoutError struct {
+	// errors in the order the branches were added,
+	// nil for branches that succeeded
+	Branches []error
+}
+
+func (e *FanoutError) Error() string {
+	var b strings.Builder
+	b.WriteString("fanout: ")
+
+	first := true
+	for i, err := range e.Branches {
+		if err == nil {
+			continue
+		}
+
+		if !first {
+			b.WriteString("; ")
+		}
+		first = false
+
+		fmt.Fprintf(&b, "branch %d: %v", i, err)
+	}
+
+	return b.String()
+}
+
+func (e *FanoutError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Branches))
+	for _, err := range e.Branches {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// fanoutConfig configures how the stream is handed to the branches.
+type fanoutConfig struct {
+	policy FanoutPolicy
+	// buffer of every branch, nil for a synchronous pipe
+	buffer *bufferConfig
+}
+
+// branchWriter writes into the pipe of a branch.
+// Once the branch returned, writes to it are discarded.
+type branchWriter struct {
+	pipe     pipeWriter
+	detached atomic.Bool
+}
+
+func (b *branchWriter) Write(p []byte) (int, error) {
+	if b.detached.Load() {
+		return len(p), nil
+	}
+
+	n, err := b.pipe.Write(p)
+	if err != nil && b.detached.Load() {
+		// the branch returned while the write was blocked
+		return len(p), nil
+	}
+
+	return n, err
+}
+
+// multiProcess runs every reader of next in its own goroutine
+// and lets distribute write the stream to their writers.
+// A branch that returns early is detached, so it does not block the others.
+// If branches fail, a FanoutError is returned.
+// Errors reading the stream are returned as they are.
+func multiProcess(next []Reader, config fanoutConfig, distribute func(writers []io.Writer, r io.Reader) error) Reader {
+	return func(r io.Reader) error {
+		ctx, cancel := context.WithCancel(ContextOf(r))
+		defer cancel()
+
+		branches := make([]*branchWriter, len(next))
+		readers := make([]pipeReader, len(next))
+		buffers := make([]*spillBuffer, len(next))
+		writers := make([]io.Writer, len(next))
+		errs := make([]error, len(next))
+
+		// closes the pipes of all branches with the error
+		closeAll := func(err error) {
+			for _, b := range branches {
+				b.pipe.CloseWithError(err)
+			}
+		}
+
+		for i := range next {
+			reader, writer, buffer := config.buffer.newPipe()
+			readers[i] = reader
+			buffers[i] = buffer
+			branches[i] = &branchWriter{pipe: writer}
+			writers[i] = branches[i]
+
+			// tear down the pipe when the execution is cancelled
+			// or when multiProcess returns
+			context.AfterFunc(ctx, func() {
+				writer.CloseWithError(ctx.Err())
+			})
+		}
+
+		var wg sync.WaitGroup
+		for i := range next {
+			wg.Add(1)
+			go func(i int, read Reader, reader pipeReader) {
+				defer wg.Done()
+
+				err := read(WithContext(ctx, reader))
+				errs[i] = err
+
+				branches[i].detached.Store(true)
+				reader.CloseWithError(io.ErrClosedPipe)
+
+				if buffers[i] != nil && config.buffer.stats != nil {
+					config.buffer.stats(buffers[i].stats(i))
+				}
+
+				if err != nil && config.policy == FailAll {
+					closeAll(ErrFanoutCancelled)
+				}
+			}(i, next[i], readers[i])
+		}
+
+		// errors reading the input, set before they reach the branches
+		input := &countingReader{Reader: r}
+		inputErr := make(chan error, 1)
+
+		go func() {
+			// hand errors of the input to every branch, binding ctx
+			// stops the distribution once all branches returned
+			err := distribute(writers, WithContext(ctx, input))
+			inputErr <- input.err
+			closeAll(err)
+		}()
+
+		wg.Wait()
+
+		// branches failing because of the input did not fail themselves
+		select {
+		case err := <-inputErr:
+			if err != nil {
+				return err
+			}
+		default:
+		}
+
+		for _, err := range errs {
+			if err != nil {
+				return &FanoutError{Branches: errs}
+			}
+		}
+
+		return ContextOf(r).Err()
+	}
+}
@@ -1,5 +1,7 @@
 package pipeline
 
+import "context"
+
 var _ FanoutBuilder = &fanoutBuilder{}
 var _ Pipeline = &fanoutBuilder{}
 
@@ -12,34 +14,127 @@ func newFanoutBuilder(in *input) *fanoutBuilder {
 type fanoutBuilder struct {
 	in *input
 
-	reader []Reader
+	// routes without their Next, which is built by Build
+	routes   []Route
+	branches []func(output OutputBuilder) Pipeline
+	config   fanoutConfig
+
+	archive *archiveSink
 
-	pipeline func() error
+	pipeline func(ctx context.Context) error
 }
 
 // Execute implements Pipeline.
 func (f *fanoutBuilder) Execute() error {
-	return f.pipeline()
+	return f.ExecuteContext(context.Background())
+}
+
+// ExecuteContext implements Pipeline.
+func (f *fanoutBuilder) ExecuteContext(ctx context.Context) error {
+	return f.pipeline(ctx)
 }
 
 // Build implements FanoutPipeline.
 func (f *fanoutBuilder) Build() Pipeline {
+	// the branches are built last, so they see
+	// the archive regardless of the order of the calls
+	routes := make([]Route, len(f.routes))
+	for i, route := range f.routes {
+		route.Next = f.branch(f.branches[i])
+		routes[i] = route
+	}
 
-	var readerWithSize = f.in.processing(MultiProcess(f.reader...))
+	var readerWithSize = f.in.processing(f.distribute(routes))
 
-	f.pipeline = func() error {
-		return f.in.source(readerWithSize)
+	f.pipeline = func(ctx context.Context) error {
+		if f.archive == nil {
+			return f.in.source(ctx, readerWithSize)
+		}
+
+		if err := f.archive.open(); err != nil {
+			return err
+		}
+
+		return f.archive.close(f.in.source(ctx, readerWithSize))
 	}
 
 	return f
 }
 
-// Register implements FanoutPipeline.
-func (f *fanoutBuilder) Register(o func(output OutputBuilder) Pipeline) FanoutBuilder {
+// distribute returns the reader handing the stream to the branches,
+// the stream is only split into lines if a branch is routed.
+func (f *fanoutBuilder) distribute(routes []Route) Reader {
+	routed := false
+	readers := make([]Reader, len(routes))
+	for i, route := range routes {
+		readers[i] = route.Next
+		routed = routed || route.Match != nil || route.Default
+	}
+
+	if routed {
+		return routeLines(routes, f.config)
+	}
+
+	return multiProcess(readers, f.config, copyToAll)
+}
+
+// branch builds the output of a branch.
+func (f *fanoutBuilder) branch(o func(output OutputBuilder) Pipeline) Reader {
 	builder := newOutputBuilderFanout()
+	builder.archive = f.archive
 	o(builder)
 
-	f.reader = append(f.reader, builder.output)
+	return builder.output
+}
+
+// add adds a branch with its route.
+func (f *fanoutBuilder) add(route Route, o func(output OutputBuilder) Pipeline) FanoutBuilder {
+	f.routes = append(f.routes, route)
+	f.branches = append(f.branches, o)
+	return f
+}
+
+// Register implements FanoutPipeline.
+func (f *fanoutBuilder) Register(o func(output OutputBuilder) Pipeline) FanoutBuilder {
+	return f.add(Route{}, o)
+}
+
+// Route implements FanoutBuilder.
+func (f *fanoutBuilder) Route(predicate RoutePredicate, o func(output OutputBuilder) Pipeline) FanoutBuilder {
+	return f.add(Route{Match: predicate}, o)
+}
+
+// Default implements FanoutBuilder.
+func (f *fanoutBuilder) Default(o func(output OutputBuilder) Pipeline) FanoutBuilder {
+	return f.add(Route{Default: true}, o)
+}
+
+// OnBranchError implements FanoutBuilder.
+func (f *fanoutBuilder) OnBranchError(policy FanoutPolicy) FanoutBuilder {
+	f.config.policy = policy
+	return f
+}
+
+// Buffer implements FanoutBuilder.
+func (f *fanoutBuilder) Buffer(memory int64, disk int64, stats func(BufferStats)) FanoutBuilder {
+	memory, disk = max(memory, 0), max(disk, 0)
+
+	// a buffer without room would block every write
+	if memory+disk == 0 {
+		f.config.buffer = nil
+		return f
+	}
+
+	f.config.buffer = &bufferConfig{
+		memory: memory,
+		disk:   disk,
+		stats:  stats,
+	}
+	return f
+}
 
+// ToArchive implements FanoutBuilder.
+func (f *fanoutBuilder) ToArchive(path string, format ArchiveFormat) FanoutBuilder {
+	f.archive = &archiveSink{name: path, format: format}
 	return f
 }
@@ -0,0 +1,125 @@
+package pipeline_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// failing returns a processing step that fails after reading n bytes.
+func failing(n int64, err error) pipeline.Processor {
+	return func(next pipeline.Reader) pipeline.Reader {
+		return func(r io.Reader) error {
+			if _, e := io.CopyN(io.Discard, r, n); e != nil {
+				return e
+			}
+			return err
+		}
+	}
+}
+
+func TestFanoutFailAll(t *testing.T) {
+	// the source delivers a line and then blocks
+	source, sourceWriter := io.Pipe()
+	defer sourceWriter.Close()
+	go io.WriteString(sourceWriter, "a\n")
+
+	failure := errors.New("failure")
+	var out strings.Builder
+
+	err := pipeline.Build().FromReader(source, -1).
+		Fanout().
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ReadOnly().AddReadonlyProcessor(failing(2, failure)).Build()
+		}).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&out).Build()
+		}).
+		Build().Execute()
+
+	var fanoutErr *pipeline.FanoutError
+	assert.ErrorAs(t, err, &fanoutErr)
+	assert.ErrorIs(t, err, failure)
+	assert.Len(t, fanoutErr.Branches, 2)
+	assert.Equal(t, failure, fanoutErr.Branches[0])
+	assert.ErrorIs(t, fanoutErr.Branches[1], pipeline.ErrFanoutCancelled)
+}
+
+func TestFanoutIsolate(t *testing.T) {
+	content := strings.Repeat("line\n", 10000)
+	r := strings.NewReader(content)
+
+	failure := errors.New("failure")
+	var out strings.Builder
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		Fanout().
+		OnBranchError(pipeline.Isolate).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ReadOnly().AddReadonlyProcessor(failing(5, failure)).Build()
+		}).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&out).Build()
+		}).
+		Build().Execute()
+
+	var fanoutErr *pipeline.FanoutError
+	assert.ErrorAs(t, err, &fanoutErr)
+	assert.Equal(t, []error{failure, nil}, fanoutErr.Branches)
+	assert.Equal(t, "fanout: branch 0: failure", err.Error())
+	assert.Equal(t, content, out.String())
+}
+
+func TestFanoutInputError(t *testing.T) {
+	r := strings.NewReader("1\nx\n3\n")
+	failure := errors.New("failure")
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ParseLines(func(line string) ([]byte, error) {
+			if line == "x" {
+				return nil, failure
+			}
+			return []byte(line), nil
+		}).
+		Fanout().
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ReadOnly().Build()
+		}).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ReadOnly().Build()
+		}).
+		Build().Execute()
+
+	var fanoutErr *pipeline.FanoutError
+	assert.False(t, errors.As(err, &fanoutErr))
+
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.Equal(t, 2, stageErr.Line)
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestFanoutBranchReturnsEarly(t *testing.T) {
+	content := strings.Repeat("line\n", 10000)
+	r := strings.NewReader(content)
+
+	var out strings.Builder
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		Fanout().
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			// only reads the head of the stream
+			return output.ReadOnly().AddReadonlyProcessor(failing(5, nil)).Build()
+		}).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&out).Build()
+		}).
+		Build().Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, content, out.String())
+}
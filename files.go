@@ -0,0 +1,352 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FilesOption configures how multiple files are read as one stream.
+type FilesOption func(*filesConfig)
+
+type filesConfig struct {
+	separator      string
+	decompress     Codec
+	decompressAuto bool
+}
+
+func newFilesConfig(opts []FilesOption) *filesConfig {
+	c := &filesConfig{}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// FileSeparator is inserted between the files.
+func FileSeparator(separator string) FilesOption {
+	return func(c *filesConfig) {
+		c.separator = separator
+	}
+}
+
+// DecompressEachFile decompresses every file on its own with the codec.
+// As the decompressed size is unknown, the size of the stream is reported as -1.
+func DecompressEachFile(codec Codec) FilesOption {
+	return func(c *filesConfig) {
+		c.decompress = codec
+	}
+}
+
+// DecompressEachFileAuto detects the compression of every file on its own,
+// so compressed and uncompressed files can be mixed, see DecompressAuto.
+// As the decompressed size is unknown, the size of the stream is reported as -1.
+func DecompressEachFileAuto() FilesOption {
+	return func(c *filesConfig) {
+		c.decompressAuto = true
+	}
+}
+
+func FromFiles(paths []string, next ReaderWithSize, opts ...FilesOption) error {
+	return FromFilesContext(context.Background(), paths, next, opts...)
+}
+
+// FromFilesContext reads the files one after another as a single stream,
+// the reported size is the sum of the file sizes.
+func FromFilesContext(ctx context.Context, paths []string, next ReaderWithSize, opts ...FilesOption) error {
+	config := newFilesConfig(opts)
+
+	var size int64
+	for i, path := range paths {
+		stats, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		size += stats.Size()
+		if i > 0 {
+			size += int64(len(config.separator))
+		}
+	}
+
+	if config.decompressAuto || config.decompress != NoCompression {
+		size = -1
+	}
+
+	reader := &filesReader{
+		next:   nextPath(paths),
+		config: config,
+	}
+	defer reader.Close()
+
+	return next(WithContext(ctx, reader), size)
+}
+
+func FromGlob(pattern string, next ReaderWithSize, opts ...FilesOption) error {
+	return FromGlobContext(context.Background(), pattern, next, opts...)
+}
+
+// FromGlobContext reads all files matching the pattern
+// in lexical order as a single stream, see FromFilesContext.
+func FromGlobContext(ctx context.Context, pattern string, next ReaderWithSize, opts ...FilesOption) error {
+	paths, err := glob(pattern)
+	if err != nil {
+		return err
+	}
+
+	return FromFilesContext(ctx, paths, next, opts...)
+}
+
+// glob returns the sorted files matching the pattern
+// and fails if there are none.
+func glob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := matches[:0]
+	for _, match := range matches {
+		stats, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !stats.IsDir() {
+			paths = append(paths, match)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("glob: no files match %q", pattern)
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// filesReader reads files one after another,
+// only the current file is open.
+type filesReader struct {
+	// next opens the next file, it returns io.EOF after the last file
+	next   func() (name string, file io.ReadCloser, err error)
+	config *filesConfig
+
+	opened  int
+	current io.ReadCloser
+	pending io.Reader
+}
+
+// nextPath returns a function that opens the paths one after another.
+func nextPath(paths []string) func() (string, io.ReadCloser, error) {
+	return func() (string, io.ReadCloser, error) {
+		if len(paths) == 0 {
+			return "", nil, io.EOF
+		}
+
+		path := paths[0]
+		paths = paths[1:]
+
+		file, err := os.Open(path)
+		return path, file, err
+	}
+}
+
+func (f *filesReader) Read(p []byte) (int, error) {
+	for {
+		if f.pending != nil {
+			n, err := f.pending.Read(p)
+			if err == io.EOF {
+				f.pending = nil
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if f.current == nil {
+			if err := f.open(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		n, err := f.current.Read(p)
+		if err == io.EOF {
+			if err := f.Close(); err != nil {
+				return n, err
+			}
+
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+
+		return n, err
+	}
+}
+
+func (f *filesReader) open() error {
+	name, file, err := f.next()
+	if err != nil {
+		return err
+	}
+
+	var decompressed io.ReadCloser
+	if f.config.decompressAuto {
+		decompressed, err = newAutoDecompressor(file, source{name: name})
+	} else {
+		decompressed, err = newDecompressor(f.config.decompress, file)
+	}
+
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if f.opened > 0 && len(f.config.separator) > 0 {
+		f.pending = strings.NewReader(f.config.separator)
+	}
+	f.opened++
+
+	f.current = &readCloser{
+		Reader: decompressed,
+		close: func() error {
+			err := decompressed.Close()
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+	}
+
+	return nil
+}
+
+func (f *filesReader) Close() error {
+	if f.current == nil {
+		return nil
+	}
+
+	err := f.current.Close()
+	f.current = nil
+
+	return err
+}
+
+// readCloser combines a reader with a close function.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
+
+// FileErrors maps the path of every failed file to its error.
+type FileErrors map[string]error
+
+func (e FileErrors) Error() string {
+	paths := make([]string, 0, len(e))
+	for path := range e {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d files failed", len(e))
+	for _, path := range paths {
+		fmt.Fprintf(&b, "\n%s: %v", path, e[path])
+	}
+
+	return b.String()
+}
+
+func (e FileErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+var _ Pipeline = &forEachFile{}
+
+// ForEachFile builds a pipeline for every file matching the pattern
+// and executes them with at most concurrency pipelines at a time.
+// All files are processed even if some fail,
+// the failures are returned as FileErrors.
+func ForEachFile(pattern string, build func(path string) Pipeline, concurrency int) Pipeline {
+	return &forEachFile{
+		pattern:     pattern,
+		build:       build,
+		concurrency: max(concurrency, 1),
+	}
+}
+
+type forEachFile struct {
+	pattern     string
+	build       func(path string) Pipeline
+	concurrency int
+}
+
+// Execute implements Pipeline.
+func (f *forEachFile) Execute() error {
+	return f.ExecuteContext(context.Background())
+}
+
+// ExecuteContext implements Pipeline.
+func (f *forEachFile) ExecuteContext(ctx context.Context) error {
+	paths, err := glob(f.pattern)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		path string
+		err  error
+	}
+
+	jobs := make(chan string)
+	results := make(chan result)
+
+	for i := 0; i < f.concurrency; i++ {
+		go func() {
+			for path := range jobs {
+				results <- result{path: path, err: f.build(path).ExecuteContext(ctx)}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, path := range paths {
+			jobs <- path
+		}
+	}()
+
+	errs := make(FileErrors)
+	for range paths {
+		r := <-results
+		if r.err != nil {
+			errs[r.path] = r.err
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return ctx.Err()
+}
@@ -0,0 +1,127 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFiles creates the files in a temporary directory,
+// contents ending with .gz are gzip compressed.
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+
+	for name, content := range files {
+		data := []byte(content)
+
+		if strings.HasSuffix(name, ".gz") {
+			r := strings.NewReader(content)
+			var b bytes.Buffer
+			err := pipeline.Build().FromReader(r, r.Size()).
+				ToWriter(&b).CompressGzip(true).Build().Execute()
+			assert.NoError(t, err)
+			data = b.Bytes()
+		}
+
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+		assert.NoError(t, os.WriteFile(path, data, 0666))
+	}
+
+	return dir
+}
+
+func TestFromGlob(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"logs/2026-10-02.log": "b1\nb2",
+		"logs/2026-10-01.log": "a1\na2",
+		"logs/2026-09-30.log": "ignored",
+	})
+
+	var out strings.Builder
+	var size int64
+
+	err := pipeline.Build().
+		FromGlob(filepath.Join(dir, "logs", "2026-10-*.log"), pipeline.FileSeparator("\n")).
+		ProgressBar(func(s int64) io.Writer {
+			size = s
+			return io.Discard
+		}).
+		ParseLines(func(line string) ([]byte, error) {
+			return []byte(line + ";"), nil
+		}).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "a1;a2;b1;b2;", out.String())
+	assert.Equal(t, int64(11), size)
+}
+
+func TestFromFilesDecompressEachFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.gz":  "compressed\n",
+		"b.txt": "plain\n",
+	})
+
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromFiles([]string{filepath.Join(dir, "a.gz"), filepath.Join(dir, "b.txt")}, pipeline.DecompressEachFileAuto()).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "compressed\nplain\n", out.String())
+}
+
+func TestFromGlobNoMatch(t *testing.T) {
+	err := pipeline.Build().
+		FromGlob(filepath.Join(t.TempDir(), "*.log")).
+		ReadOnly().
+		Build().
+		Execute()
+
+	assert.Error(t, err)
+}
+
+func TestForEachFile(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"in/a.csv": "1,2",
+		"in/b.csv": "3,4",
+		"in/c.csv": "5,x",
+	})
+
+	p := pipeline.ForEachFile(filepath.Join(dir, "in", "*.csv"), func(path string) pipeline.Pipeline {
+		return pipeline.Build().
+			FromFile(path).
+			ParseLines(func(line string) ([]byte, error) {
+				if strings.Contains(line, "x") {
+					return nil, errors.New("invalid number")
+				}
+				return []byte(strings.ReplaceAll(line, ",", ";")), nil
+			}).
+			ToFile(strings.TrimSuffix(path, ".csv") + ".out").
+			Build()
+	}, 2)
+
+	err := p.Execute()
+
+	var fileErrs pipeline.FileErrors
+	assert.ErrorAs(t, err, &fileErrs)
+	assert.Len(t, fileErrs, 1)
+	assert.Contains(t, fileErrs, filepath.Join(dir, "in", "c.csv"))
+
+	out, err := os.ReadFile(filepath.Join(dir, "in", "b.out"))
+	assert.NoError(t, err)
+	assert.Equal(t, "3;4", string(out))
+}
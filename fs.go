@@ -0,0 +1,334 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// WritableFS is a file system files can be written to,
+// it is the writable counterpart of fs.FS.
+type WritableFS interface {
+	// OpenFile opens the named file for writing,
+	// flag and perm are interpreted like os.OpenFile does.
+	OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error)
+}
+
+// RenameFS is a WritableFS that can rename and remove files,
+// it is required to write files with Atomic.
+type RenameFS interface {
+	WritableFS
+	Rename(oldname, newname string) error
+	Remove(name string) error
+}
+
+// MkdirFS is a WritableFS with directories,
+// it is used to create the parent directories with MkdirAll.
+type MkdirFS interface {
+	WritableFS
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// ErrAtomicUnsupported is returned for atomic writes
+// to a file system that does not implement RenameFS.
+var ErrAtomicUnsupported = errors.New("pipeline: file system does not support atomic writes")
+
+// DirFS returns a WritableFS for the tree of files rooted at dir,
+// names are slash separated paths relative to dir.
+// An empty dir uses the names as they are.
+func DirFS(dir string) WritableFS {
+	return osFS(dir)
+}
+
+// osFS writes to the file system of the operating system.
+type osFS string
+
+func (dir osFS) path(name string) string {
+	if dir == "" {
+		return name
+	}
+
+	return filepath.Join(string(dir), filepath.FromSlash(name))
+}
+
+// OpenFile implements WritableFS.
+func (dir osFS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(dir.path(name), flag, perm)
+}
+
+// Rename implements RenameFS.
+func (dir osFS) Rename(oldname, newname string) error {
+	return os.Rename(dir.path(oldname), dir.path(newname))
+}
+
+// Remove implements RenameFS.
+func (dir osFS) Remove(name string) error {
+	return os.Remove(dir.path(name))
+}
+
+// MkdirAll implements MkdirFS.
+func (dir osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(dir.path(name), perm)
+}
+
+// Stat returns the file info of the named file.
+func (dir osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(dir.path(name))
+}
+
+// FileOption configures the file written by ToNewFile and AppendToFile.
+type FileOption func(*fileConfig)
+
+type fileConfig struct {
+	fs   WritableFS
+	perm fs.FileMode
+
+	// create the parent directories with dirPerm
+	mkdir   bool
+	dirPerm fs.FileMode
+
+	atomic      bool
+	noOverwrite bool
+	sync        bool
+
+	// open files of ToPartitions
+	maxOpen int
+}
+
+func newFileConfig(opts []FileOption) *fileConfig {
+	c := &fileConfig{
+		fs:      osFS(""),
+		perm:    0666,
+		maxOpen: 64,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// FileSystem writes the file to fsys instead of the local disk.
+func FileSystem(fsys WritableFS) FileOption {
+	return func(c *fileConfig) {
+		c.fs = fsys
+	}
+}
+
+// Atomic writes the output of ToNewFile into a temporary file next to
+// the file, which replaces the file only if the pipeline succeeded.
+// Readers of the file never see a partially written output.
+func Atomic() FileOption {
+	return func(c *fileConfig) {
+		c.atomic = true
+	}
+}
+
+// FileMode sets the permissions of created files, by default 0666 before the umask.
+func FileMode(perm fs.FileMode) FileOption {
+	return func(c *fileConfig) {
+		c.perm = perm
+	}
+}
+
+// MkdirAll creates the missing parent directories of the file with perm.
+// File systems without directories, that do not implement MkdirFS, are left as they are.
+func MkdirAll(perm fs.FileMode) FileOption {
+	return func(c *fileConfig) {
+		c.mkdir = true
+		c.dirPerm = perm
+	}
+}
+
+// NoOverwrite lets ToNewFile fail with fs.ErrExist if the file already exists.
+// Combined with Atomic the check is done before the output starts
+// and again before the temporary file is renamed.
+func NoOverwrite() FileOption {
+	return func(c *fileConfig) {
+		c.noOverwrite = true
+	}
+}
+
+// Sync flushes the file to stable storage before it is closed,
+// files written with Atomic are always synced.
+func Sync() FileOption {
+	return func(c *fileConfig) {
+		c.sync = true
+	}
+}
+
+// MaxOpenFiles limits the files ToPartitions keeps open, by default 64.
+// The least recently written file is closed and reopened
+// for appending once it is written again. Zero disables the limit.
+func MaxOpenFiles(n int) FileOption {
+	return func(c *fileConfig) {
+		c.maxOpen = n
+	}
+}
+
+// write opens the file at path with flag and hands it to write.
+func (c *fileConfig) write(path string, flag int, write func(w io.Writer) error) error {
+	if err := c.mkdirAll(path); err != nil {
+		return err
+	}
+
+	file, err := c.fs.OpenFile(path, flag, c.perm)
+	if err != nil {
+		return err
+	}
+
+	err = write(file)
+	if err == nil && c.sync {
+		err = syncFile(file)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// mkdirAll creates the parent directories of path if configured.
+func (c *fileConfig) mkdirAll(path string) error {
+	fsys, ok := c.fs.(MkdirFS)
+	if !c.mkdir || !ok {
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	if dir == "." {
+		return nil
+	}
+
+	return fsys.MkdirAll(dir, c.dirPerm)
+}
+
+// checkOverwrite fails with fs.ErrExist if NoOverwrite
+// is set and the file at path exists.
+func (c *fileConfig) checkOverwrite(path string) error {
+	if !c.noOverwrite {
+		return nil
+	}
+
+	var err error
+	if s, ok := c.fs.(interface {
+		Stat(name string) (fs.FileInfo, error)
+	}); ok {
+		_, err = s.Stat(path)
+	} else {
+		// opening without O_CREATE does not modify the file
+		var file io.WriteCloser
+		file, err = c.fs.OpenFile(path, os.O_WRONLY, 0)
+		if err == nil {
+			file.Close()
+		}
+	}
+
+	if err == nil {
+		return &fs.PathError{Op: "open", Path: path, Err: fs.ErrExist}
+	} else if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// writeAtomic hands a temporary file to write, syncs it
+// and renames it to path. On errors the temporary file is removed.
+func (c *fileConfig) writeAtomic(path string, write func(w io.Writer) error) error {
+	fsys, ok := c.fs.(RenameFS)
+	if !ok {
+		return ErrAtomicUnsupported
+	}
+
+	if err := c.mkdirAll(path); err != nil {
+		return err
+	}
+
+	if err := c.checkOverwrite(path); err != nil {
+		return err
+	}
+
+	tmp, file, err := c.createTemp(path)
+	if err != nil {
+		return err
+	}
+
+	err = write(file)
+	if err == nil {
+		err = syncFile(file)
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = c.checkOverwrite(path)
+	}
+
+	if err == nil {
+		err = fsys.Rename(tmp, path)
+	}
+
+	if err != nil {
+		fsys.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// createTemp creates a new temporary file in the directory of path.
+func (c *fileConfig) createTemp(path string) (string, io.WriteCloser, error) {
+	for {
+		tmp := path + ".tmp-" + strconv.FormatUint(uint64(rand.Uint32()), 36)
+
+		file, err := c.fs.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, c.perm)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+
+		return tmp, file, err
+	}
+}
+
+// syncFile flushes the file to stable storage
+// if the file system supports it.
+func syncFile(file io.Writer) error {
+	if s, ok := file.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+
+	return nil
+}
+
+func FromFS(fsys fs.FS, name string, next ReaderWithSize) error {
+	return FromFSContext(context.Background(), fsys, name, next)
+}
+
+// FromFSContext reads the named file of fsys,
+// for example of an embed.FS or an fstest.MapFS.
+func FromFSContext(ctx context.Context, fsys fs.FS, name string, next ReaderWithSize) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stats, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	ctx = withSource(ctx, source{name: name})
+
+	return next(WithContext(ctx, file), stats.Size())
+}
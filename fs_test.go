@@ -0,0 +1,321 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+// memFS is an in-memory pipeline.WritableFS.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*bytes.Buffer
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: make(map[string]*bytes.Buffer)}
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm fs.FileMode) (io.WriteCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, ok := m.files[name]
+	if ok && flag&os.O_EXCL != 0 {
+		return nil, fs.ErrExist
+	} else if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, fs.ErrNotExist
+		}
+		file = &bytes.Buffer{}
+		m.files[name] = file
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		file.Reset()
+	}
+
+	return &memFile{fs: m, file: file}, nil
+}
+
+func (m *memFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, ok := m.files[oldname]
+	if !ok {
+		return fs.ErrNotExist
+	}
+
+	delete(m.files, oldname)
+	m.files[newname] = file
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.files, name)
+	return nil
+}
+
+// names returns the names of all files.
+func (m *memFS) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (m *memFS) content(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if file, ok := m.files[name]; ok {
+		return file.String()
+	}
+	return ""
+}
+
+type memFile struct {
+	fs   *memFS
+	file *bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	return f.file.Write(p)
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func TestFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"data/input.txt": {Data: []byte("a\nb")},
+	}
+
+	var out strings.Builder
+	var size int64
+
+	err := pipeline.Build().
+		FromFS(fsys, "data/input.txt").
+		ProgressBar(func(s int64) io.Writer {
+			size = s
+			return io.Discard
+		}).
+		ParseLines(func(line string) ([]byte, error) {
+			return []byte(strings.ToUpper(line)), nil
+		}).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AB", out.String())
+	assert.Equal(t, int64(3), size)
+
+	err = pipeline.Build().FromFS(fsys, "missing.txt").ToWriter(io.Discard).Build().Execute()
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestToFileSystem(t *testing.T) {
+	fsys := newMemFS()
+
+	build := func(content string) pipeline.InputBuilder {
+		r := strings.NewReader(content)
+		return pipeline.Build().FromReader(r, r.Size())
+	}
+
+	err := build("first").ToFile("out.txt", pipeline.FileSystem(fsys)).Build().Execute()
+	assert.NoError(t, err)
+	err = build("second").ToFile("out.txt", pipeline.FileSystem(fsys)).Build().Execute()
+	assert.NoError(t, err)
+	assert.Equal(t, "second", fsys.content("out.txt"))
+
+	err = build("+appended").AppendToFile("out.txt", pipeline.FileSystem(fsys)).Build().Execute()
+	assert.NoError(t, err)
+	assert.Equal(t, "second+appended", fsys.content("out.txt"))
+}
+
+func TestDirFS(t *testing.T) {
+	dir := t.TempDir()
+	r := strings.NewReader("content")
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToFile("out.txt", pipeline.FileSystem(pipeline.DirFS(dir))).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+}
+
+func TestToFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("previous"), 0666))
+
+	failure := errors.New("failure")
+
+	r := strings.NewReader("a\nb\nc")
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ParseLines(func(line string) ([]byte, error) {
+			if line == "c" {
+				return nil, failure
+			}
+			return []byte(line), nil
+		}).
+		ToFile(path, pipeline.Atomic()).
+		Build().Execute()
+	assert.ErrorIs(t, err, failure)
+
+	// the previous file is untouched and the temporary file removed
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "previous", string(data))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	r = strings.NewReader("a\nb")
+	err = pipeline.Build().FromReader(r, r.Size()).
+		ToFile(path, pipeline.Atomic()).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "a\nb", string(data))
+}
+
+func TestToFileAtomicFileSystem(t *testing.T) {
+	fsys := newMemFS()
+
+	var seen []string
+	r := io.MultiReader(strings.NewReader("content"), readerFunc(func(p []byte) (int, error) {
+		// the output is written to a temporary file
+		seen = fsys.names()
+		return 0, io.EOF
+	}))
+
+	err := pipeline.Build().FromReader(r, -1).
+		ToFile("out.txt", pipeline.FileSystem(fsys), pipeline.Atomic()).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	assert.Len(t, seen, 1)
+	assert.NotEqual(t, "out.txt", seen[0])
+	assert.Equal(t, []string{"out.txt"}, fsys.names())
+	assert.Equal(t, "content", fsys.content("out.txt"))
+
+	// the source never delivers any data
+	source, sourceWriter := io.Pipe()
+	defer sourceWriter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err = pipeline.Build().FromReader(source, -1).
+		ParseLines(func(line string) ([]byte, error) {
+			return []byte(line), nil
+		}).
+		ToFile("cancelled.txt", pipeline.FileSystem(fsys), pipeline.Atomic()).
+		Build().ExecuteContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"out.txt"}, fsys.names())
+}
+
+func TestToFileAtomicUnsupported(t *testing.T) {
+	r := strings.NewReader("content")
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToFile("out.txt", pipeline.FileSystem(writeOnlyFS{newMemFS()}), pipeline.Atomic()).
+		Build().Execute()
+	assert.ErrorIs(t, err, pipeline.ErrAtomicUnsupported)
+}
+
+// writeOnlyFS hides the Rename and Remove methods of the file system.
+type writeOnlyFS struct {
+	pipeline.WritableFS
+}
+
+func TestToFileOptions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a", "b", "out.txt")
+
+	write := func(content string, opts ...pipeline.FileOption) error {
+		r := strings.NewReader(content)
+		return pipeline.Build().FromReader(r, r.Size()).
+			ToFile(path, opts...).
+			Build().Execute()
+	}
+
+	err := write("first", pipeline.FileMode(0600), pipeline.MkdirAll(0700), pipeline.Sync())
+	assert.NoError(t, err)
+
+	stats, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0600), stats.Mode().Perm())
+
+	stats, err = os.Stat(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0700), stats.Mode().Perm())
+
+	err = write("second", pipeline.NoOverwrite())
+	assert.ErrorIs(t, err, fs.ErrExist)
+
+	err = write("second", pipeline.NoOverwrite(), pipeline.Atomic())
+	assert.ErrorIs(t, err, fs.ErrExist)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestToFileNoOverwriteFileSystem(t *testing.T) {
+	fsys := newMemFS()
+
+	write := func(content string, opts ...pipeline.FileOption) error {
+		r := strings.NewReader(content)
+		return pipeline.Build().FromReader(r, r.Size()).
+			ToFile("dir/out.txt", append(opts, pipeline.FileSystem(fsys), pipeline.MkdirAll(0777))...).
+			Build().Execute()
+	}
+
+	assert.NoError(t, write("first", pipeline.NoOverwrite(), pipeline.Atomic()))
+	assert.ErrorIs(t, write("second", pipeline.NoOverwrite(), pipeline.Atomic()), fs.ErrExist)
+	assert.ErrorIs(t, write("second", pipeline.NoOverwrite()), fs.ErrExist)
+	assert.Equal(t, "first", fsys.content("dir/out.txt"))
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// GzipOption configures gzip compression and decompression.
+type GzipOption func(*gzipConfig)
+
+type gzipConfig struct {
+	level       int
+	workers     int
+	blockSize   int
+	multistream bool
+}
+
+func newGzipConfig(opts []GzipOption) *gzipConfig {
+	c := &gzipConfig{
+		level:       DefaultLevel,
+		blockSize:   1 << 20,
+		multistream: true,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// GzipLevel sets the compression level,
+// use the levels of the gzip package from gzip.HuffmanOnly to gzip.BestCompression.
+func GzipLevel(level int) GzipOption {
+	return func(c *gzipConfig) {
+		c.level = level
+	}
+}
+
+// GzipConcurrency compresses blocks of blockSize bytes on n workers.
+// Every block becomes a gzip member of its own,
+// which trades a slightly worse ratio for throughput.
+// A blockSize of zero keeps the default of 1MB.
+func GzipConcurrency(n int, blockSize int) GzipOption {
+	return func(c *gzipConfig) {
+		c.workers = n
+		if blockSize > 0 {
+			c.blockSize = blockSize
+		}
+	}
+}
+
+// GzipMultistream sets if all members of a gzip stream are decompressed,
+// which is the default, or only the first one.
+func GzipMultistream(enable bool) GzipOption {
+	return func(c *gzipConfig) {
+		c.multistream = enable
+	}
+}
+
+func DecompressGzip(next Reader, opts ...GzipOption) Reader {
+	config := newGzipConfig(opts)
+
+	return func(r io.Reader) error {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		gz.Multistream(config.multistream)
+
+		return next(inheritContext(r, gz))
+	}
+}
+
+func CompressGzip(next Connector, opts ...GzipOption) Connector {
+	config := newGzipConfig(opts)
+
+	return func(w io.Writer, r io.Reader) error {
+		var gz io.WriteCloser
+		var err error
+
+		if config.workers > 1 {
+			gz, err = newParallelGzipWriter(w, config)
+		} else {
+			gz, err = gzip.NewWriterLevel(w, config.level)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = next(gz, r)
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+
+		return err
+	}
+}
+
+// parallelGzipWriter splits the stream into blocks,
+// which are compressed concurrently into separate gzip members.
+type parallelGzipWriter struct {
+	w      io.Writer
+	config *gzipConfig
+
+	block   []byte
+	written bool
+
+	blocks chan []byte
+	done   chan struct{}
+	err    error
+}
+
+func newParallelGzipWriter(w io.Writer, config *gzipConfig) (*parallelGzipWriter, error) {
+	// validate the level before starting any workers
+	if _, err := gzip.NewWriterLevel(io.Discard, config.level); err != nil {
+		return nil, err
+	}
+
+	p := &parallelGzipWriter{
+		w:      w,
+		config: config,
+		blocks: make(chan []byte),
+		done:   make(chan struct{}),
+	}
+
+	type member struct {
+		bytes.Buffer
+		err error
+	}
+
+	go func() {
+		defer close(p.done)
+
+		p.err = parallel(config.workers, true,
+			func() ([]byte, bool, error) {
+				block, ok := <-p.blocks
+				return block, ok, nil
+			},
+			func(block []byte) *member {
+				m := &member{}
+				gz, _ := gzip.NewWriterLevel(&m.Buffer, config.level)
+				if _, m.err = gz.Write(block); m.err == nil {
+					m.err = gz.Close()
+				}
+				return m
+			},
+			func(m *member) error {
+				if m.err != nil {
+					return m.err
+				}
+
+				_, err := p.w.Write(m.Bytes())
+				return err
+			})
+	}()
+
+	return p, nil
+}
+
+func (p *parallelGzipWriter) Write(b []byte) (int, error) {
+	written := 0
+
+	for len(b) > 0 {
+		if p.block == nil {
+			p.block = make([]byte, 0, p.config.blockSize)
+		}
+
+		n := copy(p.block[len(p.block):cap(p.block)], b)
+		p.block = p.block[:len(p.block)+n]
+		b = b[n:]
+		written += n
+
+		if len(p.block) == cap(p.block) {
+			if err := p.flushBlock(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (p *parallelGzipWriter) flushBlock() error {
+	select {
+	case p.blocks <- p.block:
+		p.block = nil
+		p.written = true
+		return nil
+	case <-p.done:
+		if p.err != nil {
+			return p.err
+		}
+		return io.ErrClosedPipe
+	}
+}
+
+func (p *parallelGzipWriter) Close() error {
+	var err error
+
+	// an empty stream still needs one gzip member
+	if len(p.block) > 0 || !p.written {
+		if p.block == nil {
+			p.block = []byte{}
+		}
+		err = p.flushBlock()
+	}
+
+	// stop the reader of the workers even if they failed,
+	// otherwise it keeps waiting for the next block
+	close(p.blocks)
+	<-p.done
+
+	if p.err != nil {
+		return p.err
+	}
+
+	return err
+}
@@ -0,0 +1,355 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPOption configures a download.
+type HTTPOption func(*httpConfig)
+
+type httpConfig struct {
+	client  *http.Client
+	header  http.Header
+	timeout time.Duration
+
+	retries int
+	backoff time.Duration
+
+	cache *httpCache
+}
+
+func newHTTPConfig(opts []HTTPOption) *httpConfig {
+	c := &httpConfig{
+		client: http.DefaultClient,
+		header: make(http.Header),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// HTTPClient sets the client used for the download,
+// by default http.DefaultClient is used.
+func HTTPClient(client *http.Client) HTTPOption {
+	return func(c *httpConfig) {
+		c.client = client
+	}
+}
+
+// HTTPHeader adds a header to the request.
+func HTTPHeader(key, value string) HTTPOption {
+	return func(c *httpConfig) {
+		c.header.Add(key, value)
+	}
+}
+
+// BasicAuth authenticates the request with username and password.
+func BasicAuth(username, password string) HTTPOption {
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return HTTPHeader("Authorization", "Basic "+credentials)
+}
+
+// BearerToken authenticates the request with the token.
+func BearerToken(token string) HTTPOption {
+	return HTTPHeader("Authorization", "Bearer "+token)
+}
+
+// HTTPTimeout limits the time of the whole download including the body.
+func HTTPTimeout(timeout time.Duration) HTTPOption {
+	return func(c *httpConfig) {
+		c.timeout = timeout
+	}
+}
+
+// HTTPRetry retries a failed download up to attempts times.
+// The wait between the attempts starts with backoff and doubles every attempt.
+// An interrupted download is resumed with a Range request from the
+// number of bytes already handed downstream. If the ETag or Last-Modified
+// header of the remote file changed, the download fails with ErrRemoteChanged.
+func HTTPRetry(attempts int, backoff time.Duration) HTTPOption {
+	return func(c *httpConfig) {
+		c.retries = attempts
+		c.backoff = backoff
+	}
+}
+
+// ErrRemoteChanged is returned if a resumed download
+// does not match the file downloaded so far.
+var ErrRemoteChanged = errors.New("http: remote file changed while resuming the download")
+
+// HTTPStatusError is returned for responses without a 2xx status code.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	// the beginning of the response body
+	Body string
+}
+
+func (e *HTTPStatusError) Error() string {
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("http: %s: %s", e.URL, e.Status)
+	}
+
+	return fmt.Sprintf("http: %s: %s: %q", e.URL, e.Status, e.Body)
+}
+
+// size of the body snippet of HTTPStatusError
+const statusErrorBodySize = 512
+
+// FromHTTP sends the request and streams the response body.
+// Responses without a 2xx status code fail with a HTTPStatusError.
+// The request is cancelled with the context of req.
+func FromHTTP(req *http.Request, next ReaderWithSize, opts ...HTTPOption) error {
+	config := newHTTPConfig(opts)
+
+	ctx := req.Context()
+	if config.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.timeout)
+		defer cancel()
+	}
+
+	req = req.Clone(ctx)
+	for key, values := range config.header {
+		req.Header[key] = values
+	}
+
+	if config.retries > 0 && req.Header.Get("Accept-Encoding") == "" {
+		// offsets of range requests refer to the encoded body,
+		// so the transport must not decode it transparently
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	var cached *cacheEntry
+	if config.cache != nil {
+		cached = config.cache.prepare(req)
+	}
+
+	resp, err := config.do(req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		if cached == nil {
+			// the request was made conditional by the caller
+			return &HTTPStatusError{URL: req.URL.Redacted(), StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return config.cache.serve(req, cached, next)
+	}
+
+	var body io.ReadCloser = resp.Body
+	if config.retries > 0 {
+		body = newResumingReader(config, req, resp)
+	}
+	defer body.Close()
+
+	ctx = withSource(ctx, source{
+		name:            req.URL.Path,
+		contentEncoding: resp.Header.Get("Content-Encoding"),
+		contentType:     resp.Header.Get("Content-Type"),
+	})
+
+	if config.cache == nil {
+		return next(WithContext(ctx, body), resp.ContentLength)
+	}
+
+	recorder, err := config.cache.record(req, resp, body)
+	if err != nil {
+		return err
+	}
+
+	err = next(WithContext(ctx, recorder), resp.ContentLength)
+	if err != nil {
+		recorder.discard()
+		return err
+	}
+
+	return recorder.commit()
+}
+
+// do sends the request and retries it as configured.
+func (c *httpConfig) do(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if err == nil {
+			if err = checkStatus(req, resp); err != nil {
+				resp.Body.Close()
+			}
+		}
+
+		if err == nil {
+			return resp, nil
+		}
+
+		if !c.retry(req.Context(), err, attempt) {
+			return nil, err
+		}
+	}
+}
+
+// retry waits before the next attempt and reports
+// if the request should be retried after err.
+func (c *httpConfig) retry(ctx context.Context, err error, attempt int) bool {
+	if attempt >= c.retries || ctx.Err() != nil || errors.Is(err, ErrRemoteChanged) {
+		return false
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < 500 && statusErr.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+
+	timer := time.NewTimer(c.backoff << attempt)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// resumingReader reads the body of a download and resumes it
+// with a Range request if the connection fails.
+type resumingReader struct {
+	config *httpConfig
+	req    *http.Request
+	resp   *http.Response
+
+	// bytes handed downstream
+	offset int64
+	// total size of the file, -1 if unknown
+	size int64
+
+	etag         string
+	lastModified string
+
+	attempt int
+	err     error
+}
+
+func newResumingReader(config *httpConfig, req *http.Request, resp *http.Response) *resumingReader {
+	return &resumingReader{
+		config:       config,
+		req:          req,
+		resp:         resp,
+		size:         resp.ContentLength,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		if r.err == nil {
+			n, err := r.resp.Body.Read(p)
+			r.offset += int64(n)
+
+			if err == io.EOF && r.size >= 0 && r.offset < r.size {
+				err = io.ErrUnexpectedEOF
+			}
+
+			if err == nil || err == io.EOF {
+				return n, err
+			}
+
+			// hand out what was read, the error is handled with the next read
+			r.err = err
+			if n > 0 {
+				return n, nil
+			}
+		}
+
+		if !r.config.retry(r.req.Context(), r.err, r.attempt) {
+			return 0, r.err
+		}
+		r.attempt++
+
+		r.err = r.resume()
+	}
+}
+
+// resume requests the rest of the file starting at the offset.
+func (r *resumingReader) resume() error {
+	r.resp.Body.Close()
+
+	req := r.req.Clone(r.req.Context())
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	if r.etag != "" {
+		req.Header.Set("If-Range", r.etag)
+	} else if r.lastModified != "" {
+		req.Header.Set("If-Range", r.lastModified)
+	}
+
+	resp, err := r.config.client.Do(req)
+	if err != nil {
+		return err
+	}
+	r.resp = resp
+
+	if err := checkStatus(req, resp); err != nil {
+		return err
+	}
+
+	if resp.Header.Get("ETag") != r.etag || resp.Header.Get("Last-Modified") != r.lastModified {
+		return ErrRemoteChanged
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		var start, end, size int64
+		_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &start, &end, &size)
+		if err != nil || start != r.offset || r.size >= 0 && size != r.size {
+			return ErrRemoteChanged
+		}
+	default:
+		// the server ignored the range and sent the whole file
+		if r.size >= 0 && resp.ContentLength >= 0 && resp.ContentLength != r.size {
+			return ErrRemoteChanged
+		}
+		if _, err := io.CopyN(io.Discard, resp.Body, r.offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *resumingReader) Close() error {
+	return r.resp.Body.Close()
+}
+
+func checkStatus(req *http.Request, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	// answer to a revalidation of the cache
+	if resp.StatusCode == http.StatusNotModified && (req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "") {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, statusErrorBodySize))
+
+	return &HTTPStatusError{
+		URL:        req.URL.Redacted(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+	}
+}
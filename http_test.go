@@ -0,0 +1,316 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "user" || password != "secret" || r.Header.Get("X-Custom") != "value" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("line1\nline2\n"))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromHTTP(req,
+			pipeline.HTTPClient(server.Client()),
+			pipeline.BasicAuth("user", "secret"),
+			pipeline.HTTPHeader("X-Custom", "value")).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "line1\nline2\n", out.String())
+}
+
+func TestFromHTTPRequestContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("first line\n"))
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+
+	start := time.Now()
+	err := pipeline.Build().
+		FromHTTP(req, pipeline.HTTPClient(server.Client())).
+		ReadOnly().
+		Build().
+		Execute()
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// the execution context still cancels the request
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	err = pipeline.Build().
+		FromHTTP(req, pipeline.HTTPClient(server.Client())).
+		ReadOnly().
+		Build().
+		ExecuteContext(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFromHTTPStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.Error(w, "<html>not here</html>", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromWeb(server.URL).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	var statusErr *pipeline.HTTPStatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusForbidden, statusErr.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+	err = pipeline.Build().
+		FromHTTP(req, pipeline.BearerToken("token")).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	assert.Equal(t, "<html>not here</html>\n", statusErr.Body)
+	assert.Empty(t, out.String())
+}
+
+func TestFromHTTPTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+	err := pipeline.Build().
+		FromHTTP(req, pipeline.HTTPTimeout(20*time.Millisecond)).
+		ReadOnly().
+		Build().
+		Execute()
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// flakyServer serves data, but aborts the first response after half of the data.
+func flakyServer(t *testing.T, data []byte, etags ...string) (*httptest.Server, *[]string) {
+	var ranges []string
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := etags[min(requests, len(etags)-1)]
+		requests++
+		ranges = append(ranges, r.Header.Get("Range"))
+
+		w.Header().Set("ETag", etag)
+
+		if requests == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data[:len(data)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &ranges
+}
+
+func TestFromWebResume(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789\n", 10000))
+	server, ranges := flakyServer(t, data, `"v1"`)
+
+	var out bytes.Buffer
+
+	err := pipeline.Build().
+		FromWeb(server.URL, pipeline.HTTPRetry(3, time.Millisecond)).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, data, out.Bytes())
+	assert.Len(t, *ranges, 2)
+	assert.Equal(t, "", (*ranges)[0])
+	assert.Regexp(t, `^bytes=\d+-$`, (*ranges)[1])
+}
+
+func TestFromWebResumeRemoteChanged(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789\n", 10000))
+	server, _ := flakyServer(t, data, `"v1"`, `"v2"`)
+
+	err := pipeline.Build().
+		FromWeb(server.URL, pipeline.HTTPRetry(3, time.Millisecond)).
+		ReadOnly().
+		Build().
+		Execute()
+
+	assert.ErrorIs(t, err, pipeline.ErrRemoteChanged)
+}
+
+func TestFromWebRetryServerError(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromWeb(server.URL, pipeline.HTTPRetry(3, time.Millisecond)).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", out.String())
+	assert.Equal(t, 3, requests)
+}
+
+func TestFromWebCache(t *testing.T) {
+	const body = "cached\nbody\n"
+	var statuses []int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			statuses = append(statuses, http.StatusNotModified)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		statuses = append(statuses, http.StatusOK)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	for i := 0; i < 2; i++ {
+		var out strings.Builder
+		var size int64
+
+		err := pipeline.Build().
+			FromWeb(server.URL+"/data.txt", pipeline.HTTPCache(dir)).
+			ProgressBar(func(s int64) io.Writer {
+				size = s
+				return io.Discard
+			}).
+			ToWriter(&out).
+			Build().
+			Execute()
+
+		assert.NoError(t, err)
+		assert.Equal(t, body, out.String())
+		assert.Equal(t, int64(len(body)), size)
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusNotModified}, statuses)
+}
+
+func TestFromWebCacheFailedCommit(t *testing.T) {
+	version := "v1"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+version+`"`)
+		if r.Header.Get("If-None-Match") == `"`+version+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(version))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	download := func() (string, error) {
+		var out strings.Builder
+		err := pipeline.Build().
+			FromWeb(server.URL+"/data.txt", pipeline.HTTPCache(dir)).
+			ToWriter(&out).
+			Build().
+			Execute()
+		return out.String(), err
+	}
+
+	_, err := download()
+	assert.NoError(t, err)
+
+	bodies, err := filepath.Glob(filepath.Join(dir, "*.body"))
+	assert.NoError(t, err)
+	assert.Len(t, bodies, 1)
+
+	// the new body cannot replace the cached one
+	assert.NoError(t, os.Remove(bodies[0]))
+	assert.NoError(t, os.MkdirAll(filepath.Join(bodies[0], "blocked"), 0777))
+
+	version = "v2"
+	_, err = download()
+	assert.Error(t, err)
+
+	// the metadata of the old body is gone, so the next
+	// download is not revalidated against the stale body
+	assert.NoError(t, os.RemoveAll(bodies[0]))
+	assert.NoError(t, os.WriteFile(bodies[0], []byte("v1"), 0666))
+
+	out, err := download()
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", out)
+}
@@ -1,9 +1,12 @@
 package pipeline
 
 import (
+	"context"
 	"encoding/gob"
 	"encoding/json"
 	"io"
+	"io/fs"
+	"net/http"
 )
 
 var _ InputBuilder = &inputBuilder{}
@@ -19,24 +22,30 @@ type inputBuilder struct {
 	inputStrategyWithSize consumeReaderWithSize
 	progressBar           ProgressBarRegistrator
 
-	lineParser LineParser[[]byte]
+	lineParser        LineParser[[]byte]
+	lineParserOptions []StageOption
 
 	decoder []Processor
 
-	parser  LineParser[interface{}]
-	encoder NewEncoder
+	parser        LineParser[interface{}]
+	parserOptions []StageOption
+	encoder       NewEncoder
 
-	gzipDecompress bool
+	parseOptions []StageOption
+
+	decompress     Codec
+	decompressAuto bool
+	gzipOptions    []GzipOption
 }
 
 func (i *inputBuilder) build(next Reader) ReaderWithSize {
 
 	if i.encoder != nil && i.parser != nil {
-		next = ParseLineToCustomEncoder(i.encoder, next, i.parser)
+		next = ParseLineToCustomEncoder(i.encoder, next, i.parser, i.stageOptions(i.parserOptions)...)
 	}
 
 	if i.lineParser != nil {
-		next = ParseLine(next, i.lineParser)
+		next = ParseLine(next, i.lineParser, i.stageOptions(i.lineParserOptions)...)
 	}
 
 	// apply decoder in reverse order to match order
@@ -46,10 +55,16 @@ func (i *inputBuilder) build(next Reader) ReaderWithSize {
 		next = d(next)
 	}
 
-	if i.gzipDecompress {
-		next = DecompressGzip(next)
+	if i.decompressAuto {
+		next = DecompressAuto(next)
+	} else if i.decompress == Gzip {
+		next = DecompressGzip(next, i.gzipOptions...)
+	} else if i.decompress != NoCompression {
+		next = Decompress(i.decompress, next)
 	}
 
+	next = cancelable(next)
+
 	// configure progress bar
 	var runWithSize ReaderWithSize
 
@@ -62,6 +77,12 @@ func (i *inputBuilder) build(next Reader) ReaderWithSize {
 	return runWithSize
 }
 
+// stageOptions combines the options set on the builder
+// with the options of a single stage.
+func (i *inputBuilder) stageOptions(opts []StageOption) []StageOption {
+	return append(append([]StageOption{}, i.parseOptions...), opts...)
+}
+
 // Decode implements InputBuilder.
 func (i *inputBuilder) Decode(decoder Processor) InputBuilder {
 	i.decoder = append(i.decoder, decoder)
@@ -69,37 +90,76 @@ func (i *inputBuilder) Decode(decoder Processor) InputBuilder {
 }
 
 // ParseLinesToCustomEncoder implements InputBuilder.
-func (i *inputBuilder) ParseLinesToCustomEncoder(encoder NewEncoder, parser LineParser[interface{}]) InputBuilder {
+func (i *inputBuilder) ParseLinesToCustomEncoder(encoder NewEncoder, parser LineParser[interface{}], opts ...StageOption) InputBuilder {
 	i.encoder = encoder
 	i.parser = parser
+	i.parserOptions = opts
 
 	return i
 }
 
 // ParseLinesToJson implements InputBuilder.
-func (i *inputBuilder) ParseLinesToJson(parser LineParser[interface{}]) InputBuilder {
+func (i *inputBuilder) ParseLinesToJson(parser LineParser[interface{}], opts ...StageOption) InputBuilder {
 	i.encoder = func(w io.Writer) Encoder {
 		return json.NewEncoder(w)
 	}
 	i.parser = parser
+	i.parserOptions = opts
 
 	return i
 }
 
 // ParseLinesToGob implements InputBuilder.
-func (i *inputBuilder) ParseLinesToGob(parser LineParser[interface{}]) InputBuilder {
+func (i *inputBuilder) ParseLinesToGob(parser LineParser[interface{}], opts ...StageOption) InputBuilder {
 	i.encoder = func(w io.Writer) Encoder {
 		return gob.NewEncoder(w)
 	}
 	i.parser = parser
+	i.parserOptions = opts
 
 	return i
 }
 
 // FromFile implements PipelineBuilder.
 func (i *inputBuilder) FromFile(path string) InputBuilder {
-	i.inputStrategyWithSize = func(next ReaderWithSize) error {
-		return FromFile(path, next)
+	i.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+		return FromFileContext(ctx, path, next)
+	}
+
+	return i
+}
+
+// FromFS implements Builder.
+func (i *inputBuilder) FromFS(fsys fs.FS, name string) InputBuilder {
+	i.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+		return FromFSContext(ctx, fsys, name, next)
+	}
+
+	return i
+}
+
+// FromFiles implements Builder.
+func (i *inputBuilder) FromFiles(paths []string, opts ...FilesOption) InputBuilder {
+	i.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+		return FromFilesContext(ctx, paths, next, opts...)
+	}
+
+	return i
+}
+
+// FromGlob implements Builder.
+func (i *inputBuilder) FromGlob(pattern string, opts ...FilesOption) InputBuilder {
+	i.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+		return FromGlobContext(ctx, pattern, next, opts...)
+	}
+
+	return i
+}
+
+// FromArchive implements Builder.
+func (i *inputBuilder) FromArchive(name string, memberGlob string, opts ...FilesOption) InputBuilder {
+	i.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+		return FromArchiveContext(ctx, name, memberGlob, next, opts...)
 	}
 
 	return i
@@ -107,31 +167,75 @@ func (i *inputBuilder) FromFile(path string) InputBuilder {
 
 // FromReader implements PipelineBuilder.
 func (i *inputBuilder) FromReader(r io.Reader, size int64) InputBuilder {
-	i.inputStrategyWithSize = func(next ReaderWithSize) error {
-		return FromReader(r, size, next)
+	i.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+		return FromReaderContext(ctx, r, size, next)
 	}
 
 	return i
 }
 
 // FromWeb implements PipelineBuilder.
-func (i *inputBuilder) FromWeb(url string) InputBuilder {
-	i.inputStrategyWithSize = func(next ReaderWithSize) error {
-		return FromWeb(url, next)
+func (i *inputBuilder) FromWeb(url string, opts ...HTTPOption) InputBuilder {
+	i.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+		return FromWebContext(ctx, url, next, opts...)
+	}
+
+	return i
+}
+
+// FromHTTP implements Builder.
+func (i *inputBuilder) FromHTTP(req *http.Request, opts ...HTTPOption) InputBuilder {
+	i.inputStrategyWithSize = func(ctx context.Context, next ReaderWithSize) error {
+		// keep the deadline and cancellation of the request
+		// and also stop once the execution is cancelled
+		reqCtx, cancel := context.WithCancelCause(req.Context())
+		defer cancel(nil)
+
+		stop := context.AfterFunc(ctx, func() {
+			cancel(ctx.Err())
+		})
+		defer stop()
+
+		return FromHTTP(req.WithContext(reqCtx), next, opts...)
 	}
 
 	return i
 }
 
 // DecompressGzip implements PipelineInput.
-func (i *inputBuilder) DecompressGzip(enable bool) InputBuilder {
-	i.gzipDecompress = enable
+func (i *inputBuilder) DecompressGzip(enable bool, opts ...GzipOption) InputBuilder {
+	i.gzipOptions = opts
+	if enable {
+		return i.Decompress(Gzip)
+	} else if i.decompress == Gzip {
+		i.decompress = NoCompression
+	}
+	return i
+}
+
+// Decompress implements InputBuilder.
+func (i *inputBuilder) Decompress(codec Codec) InputBuilder {
+	i.decompress = codec
+	i.decompressAuto = false
+	return i
+}
+
+// DecompressAuto implements InputBuilder.
+func (i *inputBuilder) DecompressAuto() InputBuilder {
+	i.decompressAuto = true
 	return i
 }
 
 // ParseLines implements PipelineInput.
-func (i *inputBuilder) ParseLines(parser LineParser[[]byte]) InputBuilder {
+func (i *inputBuilder) ParseLines(parser LineParser[[]byte], opts ...StageOption) InputBuilder {
 	i.lineParser = parser
+	i.lineParserOptions = opts
+	return i
+}
+
+// OnParseError implements InputBuilder.
+func (i *inputBuilder) OnParseError(policy ParseErrorPolicy) InputBuilder {
+	i.parseOptions = append(i.parseOptions, OnParseError(policy))
 	return i
 }
 
@@ -163,23 +267,45 @@ func (i *inputBuilder) ReadOnly() ReadonlyBuilder {
 }
 
 // ToFile implements PipelineInput.
-func (i *inputBuilder) ToFile(path string) OutputConfigurationBuilder {
+func (i *inputBuilder) ToFile(path string, opts ...FileOption) OutputConfigurationBuilder {
 	out := newOutputBuilder(&input{
 		processing: i.build,
 		source:     i.inputStrategyWithSize,
 	})
-	out.ToFile(path)
+	out.ToFile(path, opts...)
 
 	return out
 }
 
 // AppendToFile implements InputBuilder.
-func (i *inputBuilder) AppendToFile(path string) OutputConfigurationBuilder {
+func (i *inputBuilder) AppendToFile(path string, opts ...FileOption) OutputConfigurationBuilder {
+	out := newOutputBuilder(&input{
+		processing: i.build,
+		source:     i.inputStrategyWithSize,
+	})
+	out.AppendToFile(path, opts...)
+
+	return out
+}
+
+// ToRotatingFiles implements OutputBuilder.
+func (i *inputBuilder) ToRotatingFiles(pattern string, maxBytes int64, maxLines int, opts ...FileOption) OutputConfigurationBuilder {
 	out := newOutputBuilder(&input{
 		processing: i.build,
 		source:     i.inputStrategyWithSize,
 	})
-	out.AppendToFile(path)
+	out.ToRotatingFiles(pattern, maxBytes, maxLines, opts...)
+
+	return out
+}
+
+// ToPartitions implements OutputBuilder.
+func (i *inputBuilder) ToPartitions(partitioner Partitioner, pathTemplate string, opts ...FileOption) OutputConfigurationBuilder {
+	out := newOutputBuilder(&input{
+		processing: i.build,
+		source:     i.inputStrategyWithSize,
+	})
+	out.ToPartitions(partitioner, pathTemplate, opts...)
 
 	return out
 }
@@ -194,3 +320,14 @@ func (i *inputBuilder) ToWriter(w io.Writer) OutputConfigurationBuilder {
 
 	return out
 }
+
+// ToArchive implements InputBuilder.
+func (i *inputBuilder) ToArchive(path string, format ArchiveFormat) OutputConfigurationBuilder {
+	out := newOutputBuilder(&input{
+		processing: i.build,
+		source:     i.inputStrategyWithSize,
+	})
+	out.ToArchive(path, format)
+
+	return out
+}
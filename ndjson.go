@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// DecodeNDJSON decodes newline delimited json, one record per line.
+// Blank lines are skipped. A line that is not valid json is handed to
+// the policy passed with the OnParseError option, so the stage can skip it
+// and continue with the next line. InputBuilder.OnParseError does not
+// reach Decode stages. Errors carry the line number.
+// Lines are not limited in length unless MaxLineSize is set.
+func DecodeNDJSON[I any](consumer func(*I) []byte, opts ...StageOption) Processor {
+	config := newStageConfig(opts)
+
+	type readLine struct {
+		scannedLine
+		truncated bool
+	}
+
+	type decodedLine struct {
+		scannedLine
+		record *I
+		err    error
+	}
+
+	return func(next Reader) Reader {
+		return func(r io.Reader) error {
+			lines := newLineReader(r)
+			lines.max = config.maxLineSize
+
+			var number int
+			var offset int64
+
+			read := func() (readLine, bool, error) {
+				for {
+					if err := lines.read(); err == io.EOF {
+						return readLine{}, false, nil
+					} else if err != nil {
+						return readLine{}, false, err
+					}
+					lines.buffered = false
+
+					line := readLine{
+						scannedLine: scannedLine{
+							text:   strings.TrimSuffix(strings.TrimSuffix(string(lines.line), "\n"), "\r"),
+							line:   number + 1,
+							offset: offset,
+						},
+						truncated: lines.truncated,
+					}
+					number++
+					offset += lines.size
+
+					if line.truncated || !isBlank(line.text) {
+						return line, true, nil
+					}
+				}
+			}
+
+			return pipeTo(r, next, func(writer io.Writer) error {
+				return parallel(config.workers, config.ordered, read,
+					func(line readLine) decodedLine {
+						if line.truncated {
+							return decodedLine{scannedLine: line.scannedLine, err: ErrLineTooLong}
+						}
+
+						var record I
+						err := json.Unmarshal([]byte(line.text), &record)
+						return decodedLine{scannedLine: line.scannedLine, record: &record, err: err}
+					},
+					func(line decodedLine) error {
+						if line.err != nil {
+							return config.onParseError(line.text, line.error("decode", line.err))
+						}
+
+						_, err := writer.Write(consumer(line.record))
+						return err
+					})
+			})
+		}
+	}
+}
+
+// EncodeNDJSON decodes records of type I with the decoder
+// and writes them as newline delimited json.
+func EncodeNDJSON[I any](decoder NewDecoder) Processor {
+	return Transcode(decoder, NewNDJSONEncoder, func(record *I) any {
+		return record
+	})
+}
+
+// NewNDJSONEncoder writes every value as json on its own line.
+func NewNDJSONEncoder(w io.Writer) Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc
+}
+
+func isBlank(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '\r':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,139 @@
+package pipeline_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+const ndjsonInput = `{"id":1}
+
+{"id":2}
+{"id":
+  
+{"id":4}
+`
+
+type ndjsonRecord struct {
+	ID int `json:"id"`
+}
+
+func ndjsonIDs(r *ndjsonRecord) []byte {
+	return []byte{byte('0' + r.ID)}
+}
+
+func TestDecodeNDJSON(t *testing.T) {
+	r := strings.NewReader(ndjsonInput)
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		Decode(pipeline.DecodeNDJSON(ndjsonIDs)).
+		ReadOnly().
+		Build().
+		Execute()
+
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.Equal(t, "decode", stageErr.Stage)
+	assert.Equal(t, 4, stageErr.Line)
+
+	var syntaxErr *json.SyntaxError
+	assert.ErrorAs(t, err, &syntaxErr)
+}
+
+func TestDecodeNDJSONSkip(t *testing.T) {
+	r := strings.NewReader(ndjsonInput)
+	var out strings.Builder
+	var skipped atomic.Int64
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		Decode(pipeline.DecodeNDJSON(ndjsonIDs, pipeline.OnParseError(pipeline.SkipParseErrors(&skipped)))).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "124", out.String())
+	assert.Equal(t, int64(1), skipped.Load())
+}
+
+func TestDecodeNDJSONBuilderPolicy(t *testing.T) {
+	// the policy of the builder only applies to ParseLines
+	r := strings.NewReader(ndjsonInput)
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		OnParseError(pipeline.SkipParseErrors(nil)).
+		Decode(pipeline.DecodeNDJSON(ndjsonIDs)).
+		ReadOnly().
+		Build().
+		Execute()
+
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.Equal(t, 4, stageErr.Line)
+}
+
+func TestDecodeNDJSONLongLine(t *testing.T) {
+	type note struct {
+		ID   int    `json:"id"`
+		Text string `json:"text"`
+	}
+
+	long := strings.Repeat("x", 70*1024)
+	input := `{"id":1,"text":"` + long + `"}` + "\n" + `{"id":2,"text":"short"}` + "\n"
+
+	decode := func(opts ...pipeline.StageOption) (string, error) {
+		r := strings.NewReader(input)
+		var out strings.Builder
+
+		err := pipeline.Build().
+			FromReader(r, r.Size()).
+			Decode(pipeline.DecodeNDJSON(func(n *note) []byte {
+				return []byte(fmt.Sprintf("%d:%d\n", n.ID, len(n.Text)))
+			}, opts...)).
+			ToWriter(&out).
+			Build().
+			Execute()
+
+		return out.String(), err
+	}
+
+	out, err := decode()
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("1:%d\n2:5\n", len(long)), out)
+
+	var skipped atomic.Int64
+	out, err = decode(pipeline.MaxLineSize(64*1024), pipeline.OnParseError(pipeline.SkipParseErrors(&skipped)))
+	assert.NoError(t, err)
+	assert.Equal(t, "2:5\n", out)
+	assert.Equal(t, int64(1), skipped.Load())
+
+	_, err = decode(pipeline.MaxLineSize(64 * 1024))
+	var stageErr *pipeline.StageError
+	assert.ErrorAs(t, err, &stageErr)
+	assert.Equal(t, "decode", stageErr.Stage)
+	assert.Equal(t, 1, stageErr.Line)
+	assert.ErrorIs(t, err, pipeline.ErrLineTooLong)
+}
+
+func TestEncodeNDJSON(t *testing.T) {
+	r := strings.NewReader("id\n1\n2\n")
+	var out strings.Builder
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		ToWriter(&out).
+		AddProcessingStep(pipeline.EncodeNDJSON[map[string]string](pipeline.NewCSVDecoder())).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n", out.String())
+}
@@ -0,0 +1,42 @@
+package pipeline
+
+// StageOption configures a parsing or decoding stage.
+type StageOption func(*stageConfig)
+
+type stageConfig struct {
+	onParseError ParseErrorPolicy
+
+	workers int
+	ordered bool
+
+	// longest line of line based stages, zero for no limit
+	maxLineSize int
+}
+
+func newStageConfig(opts []StageOption) *stageConfig {
+	c := &stageConfig{
+		onParseError: FailOnParseError(),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// MaxLineSize limits the length of the lines read by DecodeNDJSON.
+// Longer lines are handed to the OnParseError policy with ErrLineTooLong,
+// by default the length of the lines is not limited.
+func MaxLineSize(n int) StageOption {
+	return func(c *stageConfig) {
+		c.maxLineSize = n
+	}
+}
+
+// OnParseError sets the policy for lines the LineParser rejected.
+func OnParseError(policy ParseErrorPolicy) StageOption {
+	return func(c *stageConfig) {
+		c.onParseError = policy
+	}
+}
@@ -1,6 +1,9 @@
 package pipeline
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 var _ OutputBuilder = &outputBuilder{}
 var _ OutputConfigurationBuilder = &outputBuilder{}
@@ -33,10 +36,19 @@ type outputBuilder struct {
 	// where the data is written to
 	outputStep connectorToReader
 
+	// output split into multiple files, replaces the outputStep
+	rotation   *rotation
+	partitions *partitionOutput
+
+	// archive of a fanout, files become members of it
+	archive *archiveSink
+
 	preamble string
 	appendix string
 
-	compress bool
+	compress      Codec
+	compressLevel int
+	gzipOptions   []GzipOption
 
 	steps []Processor
 
@@ -44,7 +56,7 @@ type outputBuilder struct {
 	output Reader
 
 	// pipeline to execute
-	pipeline func() error
+	pipeline func(ctx context.Context) error
 }
 
 // AddReadonlyProcessor implements ReadonlyBuilder.
@@ -62,7 +74,12 @@ func (b *outputBuilder) AddProcessingStep(p Processor) OutputConfigurationBuilde
 
 // Execute implements Pipeline.
 func (o *outputBuilder) Execute() error {
-	return o.pipeline()
+	return o.ExecuteContext(context.Background())
+}
+
+// ExecuteContext implements Pipeline.
+func (o *outputBuilder) ExecuteContext(ctx context.Context) error {
+	return o.pipeline(ctx)
 }
 
 // Build implements ConfigurePipelineOutput.
@@ -70,19 +87,42 @@ func (o *outputBuilder) Build() Pipeline {
 	// configure output steps
 	var out Connector = Copy
 
-	if o.compress {
-		out = CompressGzip(out)
+	if o.compress == Gzip {
+		opts := append([]GzipOption{GzipLevel(o.compressLevel)}, o.gzipOptions...)
+		out = CompressGzip(out, opts...)
+	} else if o.compress != NoCompression {
+		out = Compress(o.compress, o.compressLevel, out)
 	}
 
 	// configure input steps
-	var input Reader = o.outputStep(out)
-
-	if len(o.appendix) != 0 {
-		input = Appendix(input, o.appendix)
-	}
-
-	if len(o.preamble) != 0 {
-		input = Preamble(input, o.preamble)
+	var input Reader
+
+	if o.partitions != nil {
+		p := o.partitions
+		maxOpen := newFileConfig(p.opts).maxOpen
+		if o.archive != nil {
+			// members of an archive cannot be reopened
+			maxOpen = 0
+		}
+
+		input = toPartitions(p.partitioner, p.pathTemplate, maxOpen, o.preamble, o.appendix, func(path string, create bool) Reader {
+			if o.archive != nil {
+				return o.archive.member(path, out)
+			}
+			if create {
+				return ToNewFile(path, out, p.opts...)
+			}
+			return AppendToFile(path, out, p.opts...)
+		})
+	} else if o.rotation != nil {
+		input = rotate(o.rotation.pattern, o.rotation.maxBytes, o.rotation.maxLines, func(path string) Reader {
+			if o.archive != nil {
+				return o.enclose(o.archive.member(path, out))
+			}
+			return o.enclose(ToNewFile(path, out, o.rotation.opts...))
+		})
+	} else {
+		input = o.enclose(o.outputStep(out))
 	}
 
 	for i := 0; i < len(o.steps); i++ {
@@ -99,13 +139,26 @@ func (o *outputBuilder) Build() Pipeline {
 
 	var readerWithSize = o.in.processing(input)
 
-	o.pipeline = func() error {
-		return o.in.source(readerWithSize)
+	o.pipeline = func(ctx context.Context) error {
+		return o.in.source(ctx, readerWithSize)
 	}
 
 	return o
 }
 
+// enclose adds the preamble and appendix to the output.
+func (o *outputBuilder) enclose(output Reader) Reader {
+	if len(o.appendix) != 0 {
+		output = Appendix(output, o.appendix)
+	}
+
+	if len(o.preamble) != 0 {
+		output = Preamble(output, o.preamble)
+	}
+
+	return output
+}
+
 // Appendix implements ConfigurePipelineOutput.
 func (o *outputBuilder) Appendix(appendix string) OutputConfigurationBuilder {
 	o.appendix = appendix
@@ -113,8 +166,20 @@ func (o *outputBuilder) Appendix(appendix string) OutputConfigurationBuilder {
 }
 
 // CompressGzip implements ConfigurePipelineOutput.
-func (o *outputBuilder) CompressGzip(enable bool) OutputConfigurationBuilder {
-	o.compress = enable
+func (o *outputBuilder) CompressGzip(enable bool, opts ...GzipOption) OutputConfigurationBuilder {
+	o.gzipOptions = opts
+	if enable {
+		return o.Compress(Gzip, DefaultLevel)
+	} else if o.compress == Gzip {
+		o.compress = NoCompression
+	}
+	return o
+}
+
+// Compress implements OutputConfigurationBuilder.
+func (o *outputBuilder) Compress(codec Codec, level int) OutputConfigurationBuilder {
+	o.compress = codec
+	o.compressLevel = level
 	return o
 }
 
@@ -135,18 +200,56 @@ func (o *outputBuilder) ReadOnly() ReadonlyBuilder {
 }
 
 // ToFile implements MakeOutputPipeline.
-func (o *outputBuilder) ToFile(path string) OutputConfigurationBuilder {
+func (o *outputBuilder) ToFile(path string, opts ...FileOption) OutputConfigurationBuilder {
 	o.outputStep = func(next Connector) Reader {
-		return ToNewFile(path, next)
+		if o.archive != nil {
+			return o.archive.member(path, next)
+		}
+		return ToNewFile(path, next, opts...)
 	}
 
 	return o
 }
 
 // AppendToFile implements OutputBuilder.
-func (o *outputBuilder) AppendToFile(path string) OutputConfigurationBuilder {
+func (o *outputBuilder) AppendToFile(path string, opts ...FileOption) OutputConfigurationBuilder {
+	o.outputStep = func(next Connector) Reader {
+		if o.archive != nil {
+			return o.archive.member(path, next)
+		}
+		return AppendToFile(path, next, opts...)
+	}
+
+	return o
+}
+
+// ToRotatingFiles implements OutputBuilder.
+func (o *outputBuilder) ToRotatingFiles(pattern string, maxBytes int64, maxLines int, opts ...FileOption) OutputConfigurationBuilder {
+	o.rotation = &rotation{
+		pattern:  pattern,
+		maxBytes: maxBytes,
+		maxLines: maxLines,
+		opts:     opts,
+	}
+
+	return o
+}
+
+// ToPartitions implements OutputBuilder.
+func (o *outputBuilder) ToPartitions(partitioner Partitioner, pathTemplate string, opts ...FileOption) OutputConfigurationBuilder {
+	o.partitions = &partitionOutput{
+		partitioner:  partitioner,
+		pathTemplate: pathTemplate,
+		opts:         opts,
+	}
+
+	return o
+}
+
+// ToArchive implements OutputBuilder.
+func (o *outputBuilder) ToArchive(path string, format ArchiveFormat) OutputConfigurationBuilder {
 	o.outputStep = func(next Connector) Reader {
-		return AppendToFile(path, next)
+		return ToArchive(path, format, next)
 	}
 
 	return o
@@ -0,0 +1,119 @@
+package pipeline
+
+import "sync"
+
+// Parallel runs the callback of a stage on n workers.
+// With ordered the output of the records is re-sequenced,
+// so the stream is identical to the one of a serial run.
+func Parallel(n int, ordered bool) StageOption {
+	return func(c *stageConfig) {
+		c.workers = n
+		c.ordered = ordered
+	}
+}
+
+// parallel calls work for every item returned by read on n workers
+// and hands the results to emit. read and emit are never called
+// concurrently. With ordered the results are emitted in the order
+// the items were read. read returns false once there are no more items.
+func parallel[In, Out any](n int, ordered bool, read func() (In, bool, error), work func(In) Out, emit func(Out) error) error {
+	if n <= 1 {
+		for {
+			in, ok, err := read()
+			if err != nil || !ok {
+				return err
+			}
+
+			if err = emit(work(in)); err != nil {
+				return err
+			}
+		}
+	}
+
+	type job struct {
+		in  In
+		out chan Out
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	jobs := make(chan job, n)
+	queue := make(chan job, n)
+	results := make(chan Out, n)
+	readErr := make(chan error, 1)
+
+	go func() {
+		defer close(jobs)
+		defer close(queue)
+
+		for {
+			in, ok, err := read()
+			if err != nil || !ok {
+				readErr <- err
+				return
+			}
+
+			// the result channel is buffered,
+			// so workers never wait for the emitter
+			j := job{in: in, out: make(chan Out, 1)}
+
+			if ordered {
+				select {
+				case queue <- j:
+				case <-done:
+					return
+				}
+			}
+
+			select {
+			case jobs <- j:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				out := work(j.in)
+
+				if ordered {
+					j.out <- out
+					continue
+				}
+
+				select {
+				case results <- out:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	if ordered {
+		for j := range queue {
+			if err := emit(<-j.out); err != nil {
+				return err
+			}
+		}
+	} else {
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		for out := range results {
+			if err := emit(out); err != nil {
+				return err
+			}
+		}
+	}
+
+	return <-readErr
+}
@@ -0,0 +1,243 @@
+package pipeline
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Partitioner writes the records read from r into partitions.
+// partition returns the writer of the partition named by key,
+// see Records.PartitionBy.
+type Partitioner func(r io.Reader, partition func(key string) (io.Writer, error)) error
+
+// PartitionBy writes every record with the encoder into the partition
+// derived by key. Every partition gets an encoder of its own, so encoders
+// writing a header like the one of NewCSVEncoder write it once per partition.
+// Encoders buffering their output are flushed after every record,
+// as the partitions may be closed and reopened between records.
+func (records Records[T]) PartitionBy(key func(T) string, encoder NewEncoder) Partitioner {
+	type partitionEncoder struct {
+		// the writer of the partition changes when it is reopened
+		writer *switchWriter
+		enc    Encoder
+	}
+
+	return func(r io.Reader, partition func(key string) (io.Writer, error)) error {
+		encoders := make(map[string]*partitionEncoder)
+
+		return records(r, func(record T) error {
+			k := key(record)
+
+			w, err := partition(k)
+			if err != nil {
+				return err
+			}
+
+			e, ok := encoders[k]
+			if !ok {
+				e = &partitionEncoder{writer: &switchWriter{}}
+				e.enc = encoder(e.writer)
+				encoders[k] = e
+			}
+			e.writer.Writer = w
+
+			if err := e.enc.Encode(record); err != nil {
+				return &StageError{Stage: "encode", Err: err}
+			}
+
+			if err := flushEncoder(e.enc); err != nil {
+				return &StageError{Stage: "encode", Err: err}
+			}
+
+			return nil
+		})
+	}
+}
+
+// switchWriter writes to a writer that can be replaced.
+type switchWriter struct {
+	io.Writer
+}
+
+// ToPartitions writes the records of the partitioner into a file per partition.
+// The files are named after pathTemplate, a format string with a verb for the
+// partition key, like "out/country=%s/part.json". Path separators and the
+// keys "." and ".." are percent-encoded, so keys cannot leave the directory
+// of the template. Use MkdirAll to create the directories of the partitions
+// and MaxOpenFiles to limit the open files.
+func ToPartitions(partitioner Partitioner, pathTemplate string, before Connector, opts ...FileOption) Reader {
+	config := newFileConfig(opts)
+
+	return toPartitions(partitioner, pathTemplate, config.maxOpen, "", "", func(path string, create bool) Reader {
+		if create {
+			return ToNewFile(path, before, opts...)
+		}
+		return AppendToFile(path, before, opts...)
+	})
+}
+
+// partitionOutput configures an output split into partitions.
+type partitionOutput struct {
+	partitioner  Partitioner
+	pathTemplate string
+	opts         []FileOption
+}
+
+// toPartitions opens the partitions lazily with part. If more than maxOpen
+// partitions are open, the least recently used one is closed. A partition
+// written again after it was closed is reopened with create set to false.
+// The preamble is written once at the beginning of every partition,
+// the appendix once at the end.
+func toPartitions(partitioner Partitioner, pattern string, maxOpen int, preamble, appendix string, part func(path string, create bool) Reader) Reader {
+	return func(r io.Reader) error {
+		p := &partitions{
+			ctx:      ContextOf(r),
+			pattern:  pattern,
+			maxOpen:  maxOpen,
+			preamble: preamble,
+			appendix: appendix,
+			part:     part,
+			open:     make(map[string]*list.Element),
+			recent:   list.New(),
+			created:  make(map[string]bool),
+		}
+
+		return p.finish(partitioner(r, p.writer))
+	}
+}
+
+// partitions tracks the open partitions of an execution.
+type partitions struct {
+	ctx      context.Context
+	pattern  string
+	maxOpen  int
+	preamble string
+	appendix string
+	part     func(path string, create bool) Reader
+
+	// open partitions by key, the most recently used at the front
+	open   map[string]*list.Element
+	recent *list.List
+
+	// partitions that were opened before
+	created map[string]bool
+}
+
+// openPartition is a partition being written by its part in the background.
+type openPartition struct {
+	key    string
+	writer *io.PipeWriter
+	stop   func() bool
+	result chan error
+}
+
+// writer returns the writer of the partition and opens it if necessary.
+func (p *partitions) writer(key string) (io.Writer, error) {
+	if e, ok := p.open[key]; ok {
+		p.recent.MoveToFront(e)
+		return e.Value.(*openPartition).writer, nil
+	}
+
+	if p.maxOpen > 0 && p.recent.Len() >= p.maxOpen {
+		if err := p.close(p.recent.Back(), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	create := !p.created[key]
+	p.created[key] = true
+
+	partition := p.start(key, create)
+	p.open[key] = p.recent.PushFront(partition)
+
+	if create && len(p.preamble) != 0 {
+		if _, err := io.WriteString(partition.writer, p.preamble); err != nil {
+			return nil, err
+		}
+	}
+
+	return partition.writer, nil
+}
+
+// start runs the part of the partition in its own goroutine.
+func (p *partitions) start(key string, create bool) *openPartition {
+	reader, writer := io.Pipe()
+	partition := &openPartition{
+		key:    key,
+		writer: writer,
+		result: make(chan error, 1),
+	}
+
+	partition.stop = context.AfterFunc(p.ctx, func() {
+		writer.CloseWithError(p.ctx.Err())
+	})
+
+	go func(read Reader) {
+		err := read(WithContext(p.ctx, reader))
+		reader.CloseWithError(io.ErrClosedPipe)
+		partition.result <- err
+	}(p.part(fmt.Sprintf(p.pattern, escapePartitionKey(key)), create))
+
+	return partition
+}
+
+// close closes the partition with err and waits for its part.
+func (p *partitions) close(e *list.Element, err error) error {
+	partition := p.recent.Remove(e).(*openPartition)
+	delete(p.open, partition.key)
+
+	partition.writer.CloseWithError(err)
+	partition.stop()
+
+	return <-partition.result
+}
+
+// finish closes all partitions with the error of the partitioner
+// and writes the appendix to every partition.
+func (p *partitions) finish(err error) error {
+	if err == nil && len(p.appendix) != 0 {
+		for key := range p.created {
+			// reopen the partitions closed before
+			w, e := p.writer(key)
+			if e == nil {
+				_, e = io.WriteString(w, p.appendix)
+			}
+			if e != nil {
+				err = e
+				break
+			}
+		}
+	}
+
+	// errors of the partitions take precedence, as writing
+	// to them fails once they returned early
+	var partErr error
+	for p.recent.Len() > 0 {
+		if e := p.close(p.recent.Front(), err); e != nil && partErr == nil {
+			partErr = e
+		}
+	}
+
+	if partErr != nil {
+		return partErr
+	}
+
+	return err
+}
+
+// escapePartitionKey percent-encodes path separators and the keys
+// "." and "..", so keys derived from the data stay inside their directory.
+// The percent sign is encoded as well to keep distinct keys distinct.
+func escapePartitionKey(key string) string {
+	switch key {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+
+	return strings.NewReplacer("%", "%25", "/", "%2F", "\\", "%5C").Replace(key)
+}
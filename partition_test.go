@@ -0,0 +1,137 @@
+package pipeline_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+type sale struct {
+	Country string
+	Amount  int
+}
+
+const sales = `{"Country":"DE","Amount":1} {"Country":"FR","Amount":2} {"Country":"DE","Amount":3} {"Country":"IT","Amount":4} {"Country":"FR","Amount":5}`
+
+func TestToPartitions(t *testing.T) {
+	dir := t.TempDir()
+
+	byCountry := pipeline.DecodeRecords[sale](newJsonDecoder).PartitionBy(func(s sale) string {
+		return s.Country
+	}, newJsonEncoder)
+
+	r := strings.NewReader(sales)
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToPartitions(byCountry, filepath.Join(dir, "country=%s", "part.json.gz"), pipeline.MkdirAll(0777)).
+		CompressGzip(true).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	expected := map[string]string{
+		"DE": "{\"Country\":\"DE\",\"Amount\":1}\n{\"Country\":\"DE\",\"Amount\":3}\n",
+		"FR": "{\"Country\":\"FR\",\"Amount\":2}\n{\"Country\":\"FR\",\"Amount\":5}\n",
+		"IT": "{\"Country\":\"IT\",\"Amount\":4}\n",
+	}
+
+	for country, content := range expected {
+		var out strings.Builder
+		err := pipeline.Build().
+			FromFile(filepath.Join(dir, "country="+country, "part.json.gz")).
+			DecompressGzip(true).
+			ToWriter(&out).Build().Execute()
+		assert.NoError(t, err)
+		assert.Equal(t, content, out.String())
+	}
+}
+
+func TestToPartitionsMaxOpenFiles(t *testing.T) {
+	fsys := newMemFS()
+
+	byCountry := pipeline.DecodeRecords[sale](newJsonDecoder).PartitionBy(func(s sale) string {
+		return s.Country
+	}, pipeline.NewCSVEncoder())
+
+	// only one file is open at a time, so every change
+	// of the country closes and reopens a partition
+	r := strings.NewReader(sales)
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToPartitions(byCountry, "%s.csv", pipeline.FileSystem(fsys), pipeline.MaxOpenFiles(1)).
+		Preamble("# sales\n").
+		Appendix("# end\n").
+		Build().Execute()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "# sales\nCountry,Amount\nDE,1\nDE,3\n# end\n", fsys.content("DE.csv"))
+	assert.Equal(t, "# sales\nCountry,Amount\nIT,4\n# end\n", fsys.content("IT.csv"))
+}
+
+func TestToPartitionsError(t *testing.T) {
+	fsys := newMemFS()
+	failure := errors.New("failure")
+
+	byCountry := pipeline.Map(pipeline.DecodeRecords[sale](newJsonDecoder), func(s sale) (sale, error) {
+		if s.Country == "IT" {
+			return s, failure
+		}
+		return s, nil
+	}).PartitionBy(func(s sale) string {
+		return s.Country
+	}, newJsonEncoder)
+
+	r := strings.NewReader(sales)
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToPartitions(byCountry, "%s.json", pipeline.FileSystem(fsys)).
+		Build().Execute()
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestToPartitionsInArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sales.tar.gz")
+
+	byCountry := pipeline.DecodeRecords[sale](newJsonDecoder).PartitionBy(func(s sale) string {
+		return s.Country
+	}, pipeline.NewCSVEncoder())
+
+	r := strings.NewReader(sales)
+	err := pipeline.Build().FromReader(r, r.Size()).
+		Fanout().
+		ToArchive(path, pipeline.ArchiveTarGzip).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToPartitions(byCountry, "country=%s/part.csv", pipeline.MaxOpenFiles(1)).
+				Appendix("# end\n").
+				Build()
+		}).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"country=DE/part.csv": "Country,Amount\nDE,1\nDE,3\n# end\n",
+		"country=FR/part.csv": "Country,Amount\nFR,2\nFR,5\n# end\n",
+		"country=IT/part.csv": "Country,Amount\nIT,4\n# end\n",
+	}, readArchive(t, path))
+}
+
+func TestToPartitionsEscapesKeys(t *testing.T) {
+	fsys := newMemFS()
+
+	byKey := pipeline.DecodeRecords[string](newJsonDecoder).PartitionBy(func(s string) string {
+		return s
+	}, newJsonEncoder)
+
+	r := strings.NewReader(`"../../x" ".." "a\\b" "50%"`)
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToPartitions(byKey, "out/k=%s.json", pipeline.FileSystem(fsys)).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{
+		"out/k=..%2F..%2Fx.json",
+		"out/k=%2E%2E.json",
+		"out/k=a%5Cb.json",
+		"out/k=50%25.json",
+	}, fsys.names())
+}
@@ -1,17 +1,15 @@
 package pipeline
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/json"
 	"encoding/xml"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strings"
-
-	"github.com/klauspost/compress/gzip"
 )
 
 type LineParser[T any] func(line string) (T, error)
@@ -28,6 +26,10 @@ type Writer func(io.Writer) error
 type Connector func(io.Writer, io.Reader) error
 
 func FromFile(path string, next ReaderWithSize) error {
+	return FromFileContext(context.Background(), path, next)
+}
+
+func FromFileContext(ctx context.Context, path string, next ReaderWithSize) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -39,21 +41,31 @@ func FromFile(path string, next ReaderWithSize) error {
 		return err
 	}
 
-	return next(file, stats.Size())
+	ctx = withSource(ctx, source{name: path})
+
+	return next(WithContext(ctx, file), stats.Size())
 }
 
 func FromReader(reader io.Reader, size int64, next ReaderWithSize) error {
-	return next(reader, size)
+	return FromReaderContext(context.Background(), reader, size, next)
+}
+
+func FromReaderContext(ctx context.Context, reader io.Reader, size int64, next ReaderWithSize) error {
+	return next(WithContext(ctx, reader), size)
+}
+
+func FromWeb(url string, next ReaderWithSize, opts ...HTTPOption) error {
+	return FromWebContext(context.Background(), url, next, opts...)
 }
 
-func FromWeb(url string, next ReaderWithSize) error {
-	resp, err := http.Get(url)
+// FromWebContext downloads url, the request is cancelled with ctx.
+func FromWebContext(ctx context.Context, url string, next ReaderWithSize, opts ...HTTPOption) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	return next(resp.Body, resp.ContentLength)
+	return FromHTTP(req, next, opts...)
 }
 
 func IgnoreSize(next Reader) ReaderWithSize {
@@ -65,19 +77,7 @@ func IgnoreSize(next Reader) ReaderWithSize {
 func ProgressBar(register ProgressBarRegistrator, next Reader) ReaderWithSize {
 	return func(r io.Reader, size int64) error {
 		reader := io.TeeReader(r, register(size))
-		return next(reader)
-	}
-}
-
-func DecompressGzip(next Reader) Reader {
-	return func(r io.Reader) error {
-		gz, err := gzip.NewReader(r)
-		if err != nil {
-			return err
-		}
-		defer gz.Close()
-
-		return next(gz)
+		return next(inheritContext(r, reader))
 	}
 }
 
@@ -86,7 +86,7 @@ func Preamble(next Reader, preamble string) Reader {
 		pr := strings.NewReader(preamble)
 		mr := io.MultiReader(pr, r)
 
-		return next(mr)
+		return next(inheritContext(r, mr))
 	}
 }
 
@@ -95,7 +95,7 @@ func Appendix(next Reader, appendix string) Reader {
 		ar := strings.NewReader(appendix)
 		mr := io.MultiReader(r, ar)
 
-		return next(mr)
+		return next(inheritContext(r, mr))
 	}
 }
 
@@ -104,65 +104,56 @@ type Encoder interface {
 	Encode(e any) error
 }
 
+// flushEncoder writes the output of encoders buffering it,
+// which implement Flush like the encoder of NewCSVEncoder.
+func flushEncoder(enc Encoder) error {
+	if f, ok := enc.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
 func Transcode[I any](decoder NewDecoder, encoder NewEncoder, consumer func(*I) any) Processor {
 	return func(next Reader) Reader {
 		return func(r io.Reader) error {
-			dec := decoder(r)
-			reader, writer := io.Pipe()
-			enc := encoder(writer)
+			counter := &countingReader{Reader: r}
+			dec := decoder(counter)
 
-			go func() {
-				defer writer.Close()
-				var err error
-				var input I
+			return pipeTo(r, next, func(writer io.Writer) error {
+				enc := encoder(writer)
 				for {
-					err = dec.Decode(&input)
+					var input I
+					err := dec.Decode(&input)
 					if err == io.EOF {
 						break
 					} else if err != nil {
-						log.Printf("error while decoding: %v", err)
-						break
+						return counter.error("decode", err)
 					}
-					out := consumer(&input)
-					err = enc.Encode(out)
+
+					err = enc.Encode(consumer(&input))
 					if err != nil {
-						log.Printf("error while encoding: %v", err)
+						return counter.error("encode", err)
 					}
 				}
-			}()
 
-			return next(reader)
+				if err := flushEncoder(enc); err != nil {
+					return counter.error("encode", err)
+				}
+
+				return nil
+			})
 		}
 	}
 }
 
-func ParseLineToCustomEncoder(encoder NewEncoder, next Reader, p LineParser[interface{}]) Reader {
-	return func(r io.Reader) error {
-		scanner := bufio.NewScanner(r)
-		reader, writer := io.Pipe()
-
-		enc := encoder(writer)
-
-		go func() {
-			defer writer.Close()
-			for scanner.Scan() {
-				line := scanner.Text()
-
-				parsed, err := p(line)
-				if err != nil {
-					// write error
-				}
-
-				err = enc.Encode(parsed)
-				if err != nil {
-					log.Printf("error encoding: %v", err)
-					writer.CloseWithError(err)
-				}
-			}
-		}()
-
-		return next(reader)
-	}
+func ParseLineToCustomEncoder(encoder NewEncoder, next Reader, p LineParser[interface{}], opts ...StageOption) Reader {
+	return parseLines(next, p, opts, func(w io.Writer) (func(parsed interface{}) error, func() error) {
+		enc := encoder(w)
+		return enc.Encode, func() error {
+			return flushEncoder(enc)
+		}
+	})
 }
 
 type NewDecoder func(r io.Reader) Decoder
@@ -171,105 +162,152 @@ type Decoder interface {
 	Decode(e any) error
 }
 
-func Decode[I any](decoder NewDecoder, consumer func(*I) []byte) Processor {
-	return func(next Reader) Reader {
-		return func(r io.Reader) error {
-			decoder := decoder(r)
-			reader, writer := io.Pipe()
+func Decode[I any](decoder NewDecoder, consumer func(*I) []byte, opts ...StageOption) Processor {
+	return decodeEach(decoder, func(input *I, w io.Writer) error {
+		_, err := w.Write(consumer(input))
+		return err
+	}, opts)
+}
 
-			go func() {
-				defer writer.Close()
-				var err error
-				var input I
-				for {
-					err = decoder.Decode(&input)
-					if err == io.EOF {
-						break
-					} else if err != nil {
-						log.Printf("error while decoding: %v", err)
-						break
-					}
-					out := consumer(&input)
-					writer.Write(out)
-				}
-			}()
+func DecodeToWriter[I any](decoder NewDecoder, consumer func(*I, io.Writer), opts ...StageOption) Processor {
+	return decodeEach(decoder, func(input *I, w io.Writer) error {
+		consumer(input, w)
+		return nil
+	}, opts)
+}
 
-			return next(reader)
-		}
+// decodeEach decodes the stream record by record
+// and lets consume write the output for each record.
+func decodeEach[I any](decoder NewDecoder, consume func(*I, io.Writer) error, opts []StageOption) Processor {
+	config := newStageConfig(opts)
+
+	type output struct {
+		bytes.Buffer
+		err error
 	}
-}
 
-func DecodeToWriter[I any](decoder NewDecoder, consumer func(*I, io.Writer)) Processor {
 	return func(next Reader) Reader {
 		return func(r io.Reader) error {
-			decoder := decoder(r)
-			reader, writer := io.Pipe()
+			counter := &countingReader{Reader: r}
+			dec := decoder(counter)
 
-			go func() {
-				defer writer.Close()
-				var err error
+			read := func() (*I, bool, error) {
 				var input I
-				for {
-					err = decoder.Decode(&input)
-					if err == io.EOF {
-						break
-					} else if err != nil {
-						log.Printf("error while decoding: %v", err)
-						break
-					}
-					consumer(&input, writer)
+				err := dec.Decode(&input)
+				if err == io.EOF {
+					return nil, false, nil
+				} else if err != nil {
+					return nil, false, counter.error("decode", err)
+				}
+
+				return &input, true, nil
+			}
+
+			return pipeTo(r, next, func(writer io.Writer) error {
+				if config.workers <= 1 {
+					return parallel(1, false, read,
+						func(input *I) error { return consume(input, writer) },
+						func(err error) error { return err })
 				}
-			}()
 
-			return next(reader)
+				// workers write into their own buffer,
+				// which is copied to the output by the emitter
+				return parallel(config.workers, config.ordered, read,
+					func(input *I) *output {
+						out := &output{}
+						out.err = consume(input, &out.Buffer)
+						return out
+					},
+					func(out *output) error {
+						if out.err != nil {
+							return out.err
+						}
+
+						_, err := writer.Write(out.Bytes())
+						return err
+					})
+			})
 		}
 	}
 }
 
-func DecodeGobToWriter[I any](consumer func(*I, io.Writer)) Processor {
+func DecodeGobToWriter[I any](consumer func(*I, io.Writer), opts ...StageOption) Processor {
 	return DecodeToWriter(func(r io.Reader) Decoder {
 		return gob.NewDecoder(r)
-	}, consumer)
+	}, consumer, opts...)
 }
 
-func DecodeGob[I any](consumer func(*I) []byte) Processor {
+func DecodeGob[I any](consumer func(*I) []byte, opts ...StageOption) Processor {
 	return Decode(func(r io.Reader) Decoder {
 		return gob.NewDecoder(r)
-	}, consumer)
+	}, consumer, opts...)
 }
 
-func DecodeJson[I any](consumer func(*I) []byte) Processor {
+func DecodeJson[I any](consumer func(*I) []byte, opts ...StageOption) Processor {
 	return Decode(func(r io.Reader) Decoder {
 		return json.NewDecoder(r)
-	}, consumer)
+	}, consumer, opts...)
 }
 
-func DecodeXML[I any](consumer func(*I) []byte) Processor {
+func DecodeXML[I any](consumer func(*I) []byte, opts ...StageOption) Processor {
 	return Decode(func(r io.Reader) Decoder {
 		return xml.NewDecoder(r)
-	}, consumer)
+	}, consumer, opts...)
 }
 
-func ParseLine(next Reader, p LineParser[[]byte]) Reader {
+func ParseLine(next Reader, p LineParser[[]byte], opts ...StageOption) Reader {
+	return parseLines(next, p, opts, func(w io.Writer) (func(parsed []byte) error, func() error) {
+		write := func(parsed []byte) error {
+			_, err := w.Write(parsed)
+			return err
+		}
+		return write, func() error { return nil }
+	})
+}
+
+// parseLines runs the parser for every line and writes the parsed
+// values with the writer created by output, flush is called at the end.
+func parseLines[T any](next Reader, p LineParser[T], opts []StageOption, output func(w io.Writer) (write func(T) error, flush func() error)) Reader {
+	config := newStageConfig(opts)
+
+	type parsedLine struct {
+		scannedLine
+		parsed T
+		err    error
+	}
+
 	return func(r io.Reader) error {
-		scanner := bufio.NewScanner(r)
-		reader, writer := io.Pipe()
+		scanner := newLineScanner(r)
+
+		return pipeTo(r, next, func(writer io.Writer) error {
+			write, flush := output(writer)
+
+			err := parallel(config.workers, config.ordered, scanner.read,
+				func(line scannedLine) parsedLine {
+					parsed, err := p(line.text)
+					return parsedLine{scannedLine: line, parsed: parsed, err: err}
+				},
+				func(line parsedLine) error {
+					if line.err != nil {
+						return config.onParseError(line.text, line.error("parse", line.err))
+					}
 
-		go func() {
-			defer writer.Close()
-			for scanner.Scan() {
-				line := scanner.Text()
+					if err := write(line.parsed); err != nil {
+						return line.error("encode", err)
+					}
 
-				parsed, err := p(line)
-				if err != nil {
-					log.Printf("error while parsing: %v", err)
-				}
+					return nil
+				})
+			if err != nil {
+				return err
+			}
 
-				writer.Write([]byte(parsed))
+			if err := flush(); err != nil {
+				return &StageError{Stage: "encode", Err: err}
 			}
-		}()
 
-		return next(reader)
+			return nil
+		})
 	}
 }
 
@@ -291,27 +329,38 @@ func ToFile(file *os.File, before Connector) Reader {
 	}
 }
 
-func AppendToFile(path string, before Connector) Reader {
-	return func(r io.Reader) error {
-		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+// AppendToFile appends to the file at path, the file is created if it does not exist.
+func AppendToFile(path string, before Connector, opts ...FileOption) Reader {
+	config := newFileConfig(opts)
 
-		return before(file, r)
+	return func(r io.Reader) error {
+		return config.write(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, func(w io.Writer) error {
+			return before(w, r)
+		})
 	}
 }
 
-func ToNewFile(path string, before Connector) Reader {
+// ToNewFile writes to the file at path, an existing file is truncated.
+// Use Atomic to replace the file only if the pipeline succeeds
+// and NoOverwrite to keep existing files.
+func ToNewFile(path string, before Connector, opts ...FileOption) Reader {
+	config := newFileConfig(opts)
+
 	return func(r io.Reader) error {
-		file, err := os.Create(path)
-		if err != nil {
-			return err
+		write := func(w io.Writer) error {
+			return before(w, r)
 		}
-		defer file.Close()
 
-		return before(file, r)
+		if config.atomic {
+			return config.writeAtomic(path, write)
+		}
+
+		flag := os.O_TRUNC | os.O_CREATE | os.O_WRONLY
+		if config.noOverwrite {
+			flag = os.O_EXCL | os.O_CREATE | os.O_WRONLY
+		}
+
+		return config.write(path, flag, write)
 	}
 }
 
@@ -320,57 +369,12 @@ func Copy(w io.Writer, r io.Reader) error {
 	return err
 }
 
-func CompressGzip(next Connector) Connector {
-	return func(w io.Writer, r io.Reader) error {
-		gzip := gzip.NewWriter(w)
-		defer gzip.Close()
-
-		return next(gzip, r)
-	}
-}
-
 func MultiProcess(next ...Reader) Reader {
-	return func(r io.Reader) error {
-		var err error
-
-		writers := make([]io.Writer, len(next))
-		closers := make([]io.Closer, len(next))
-		result := make(chan error, len(next))
-
-		for i := 0; i < len(next); i++ {
-			reader, writer := io.Pipe()
-			writers[i] = writer
-			closers[i] = writer
-
-			go func(read Reader, reader io.Reader, closer io.Closer) {
-				// defer io.Copy(io.Discard, reader)
-				err := read(reader)
-				result <- err
-			}(next[i], reader, writer)
-		}
-
-		go func() {
-			w := io.MultiWriter(writers...)
-			_, err = io.Copy(w, r)
-			if err != nil {
-				log.Printf("multiwriter copy error %v", err)
-			}
-
-			for i := 0; i < len(next); i++ {
-				err = closers[i].Close()
-				if err != nil {
-					log.Printf("mw close error %v", err)
-				}
-			}
-		}()
-
-		for i := 0; i < len(next); i++ {
-			e := <-result
-			if err == nil {
-				err = e
-			}
-		}
+	return multiProcess(next, fanoutConfig{}, copyToAll)
+}
 
-		return err
-	}
+// copyToAll copies the stream to every writer.
+func copyToAll(writers []io.Writer, r io.Reader) error {
+	_, err := io.Copy(io.MultiWriter(writers...), r)
+	return err
 }
@@ -0,0 +1,107 @@
+package pipeline
+
+import "io"
+
+// Records is a typed stream of records read from r.
+// It calls emit for every record in the order they are read.
+//
+// Records are transformed in memory with Map, Filter and FlatMap
+// and only encoded once at the end of the chain with Encode.
+type Records[T any] func(r io.Reader, emit func(T) error) error
+
+// DecodeRecords reads the records of type T with the decoder.
+func DecodeRecords[T any](decoder NewDecoder) Records[T] {
+	return func(r io.Reader, emit func(T) error) error {
+		counter := &countingReader{Reader: r}
+		dec := decoder(counter)
+
+		for {
+			var record T
+			err := dec.Decode(&record)
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return counter.error("decode", err)
+			}
+
+			if err = emit(record); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Map converts every record with f.
+func Map[T, U any](records Records[T], f func(T) (U, error)) Records[U] {
+	return func(r io.Reader, emit func(U) error) error {
+		return records(r, func(record T) error {
+			mapped, err := f(record)
+			if err != nil {
+				return err
+			}
+
+			return emit(mapped)
+		})
+	}
+}
+
+// Filter only keeps the records for which keep returns true.
+func Filter[T any](records Records[T], keep func(T) bool) Records[T] {
+	return func(r io.Reader, emit func(T) error) error {
+		return records(r, func(record T) error {
+			if !keep(record) {
+				return nil
+			}
+
+			return emit(record)
+		})
+	}
+}
+
+// FlatMap converts every record into any number of records.
+func FlatMap[T, U any](records Records[T], f func(T) ([]U, error)) Records[U] {
+	return func(r io.Reader, emit func(U) error) error {
+		return records(r, func(record T) error {
+			mapped, err := f(record)
+			if err != nil {
+				return err
+			}
+
+			for _, m := range mapped {
+				if err = emit(m); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+}
+
+// Encode writes the records with the encoder.
+// The resulting Processor can be used with Decode and AddProcessingStep.
+func (records Records[T]) Encode(encoder NewEncoder) Processor {
+	return func(next Reader) Reader {
+		return func(r io.Reader) error {
+			return pipeTo(r, next, func(writer io.Writer) error {
+				enc := encoder(writer)
+
+				err := records(r, func(record T) error {
+					if err := enc.Encode(record); err != nil {
+						return &StageError{Stage: "encode", Err: err}
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+
+				if err := flushEncoder(enc); err != nil {
+					return &StageError{Stage: "encode", Err: err}
+				}
+
+				return nil
+			})
+		}
+	}
+}
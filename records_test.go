@@ -0,0 +1,76 @@
+package pipeline_test
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func newJsonDecoder(r io.Reader) pipeline.Decoder {
+	return json.NewDecoder(r)
+}
+
+func newJsonEncoder(w io.Writer) pipeline.Encoder {
+	return json.NewEncoder(w)
+}
+
+func TestRecords(t *testing.T) {
+	type item struct {
+		Name  string
+		Count int
+	}
+
+	r := strings.NewReader(`{"Name":"a","Count":1} {"Name":"b","Count":0} {"Name":"c","Count":2}`)
+	var out strings.Builder
+
+	items := pipeline.DecodeRecords[item](newJsonDecoder)
+	counted := pipeline.Filter(items, func(i item) bool {
+		return i.Count > 0
+	})
+	names := pipeline.FlatMap(counted, func(i item) ([]string, error) {
+		return slicesRepeat(i.Name, i.Count), nil
+	})
+	upper := pipeline.Map(names, func(name string) (string, error) {
+		return strings.ToUpper(name), nil
+	})
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		Decode(upper.Encode(newJsonEncoder)).
+		ToWriter(&out).
+		Build().
+		Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "\"A\"\n\"C\"\n\"C\"\n", out.String())
+}
+
+func TestRecordsMapError(t *testing.T) {
+	r := strings.NewReader(`"1" "2" "x"`)
+	var out strings.Builder
+
+	numbers := pipeline.Map(pipeline.DecodeRecords[string](newJsonDecoder), strconv.Atoi)
+
+	err := pipeline.Build().
+		FromReader(r, r.Size()).
+		ToWriter(&out).
+		AddProcessingStep(numbers.Encode(newJsonEncoder)).
+		Build().
+		Execute()
+
+	var numErr *strconv.NumError
+	assert.ErrorAs(t, err, &numErr)
+}
+
+func slicesRepeat(s string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = s
+	}
+	return out
+}
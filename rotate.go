@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+)
+
+// ToRotatingFiles splits the stream on line boundaries into files named
+// after pattern, a format string with a verb for the part number starting
+// at 1, like "export/part-%05d.json". A file holds at most maxBytes bytes
+// and maxLines lines, zero disables the limit. A line longer than maxBytes
+// gets a file of its own. An empty stream still creates the first file.
+func ToRotatingFiles(pattern string, maxBytes int64, maxLines int, before Connector, opts ...FileOption) Reader {
+	return rotate(pattern, maxBytes, maxLines, func(path string) Reader {
+		return ToNewFile(path, before, opts...)
+	})
+}
+
+// rotation configures an output split into multiple files.
+type rotation struct {
+	pattern  string
+	maxBytes int64
+	maxLines int
+	opts     []FileOption
+}
+
+// rotate hands every chunk of the stream to the reader created by part.
+// The chunks are written one after another.
+func rotate(pattern string, maxBytes int64, maxLines int, part func(path string) Reader) Reader {
+	return func(r io.Reader) error {
+		ctx := ContextOf(r)
+		s := &splitter{
+			lineReader: newLineReader(r),
+			maxBytes:   maxBytes,
+			maxLines:   maxLines,
+		}
+
+		for n := 1; ; n++ {
+			reader, writer := io.Pipe()
+			stop := context.AfterFunc(ctx, func() {
+				writer.CloseWithError(ctx.Err())
+			})
+
+			result := make(chan error, 1)
+			go func(read Reader) {
+				err := read(WithContext(ctx, reader))
+				reader.CloseWithError(io.ErrClosedPipe)
+				result <- err
+			}(part(fmt.Sprintf(pattern, n)))
+
+			more, err := s.chunk(writer)
+			writer.CloseWithError(err)
+			stop()
+
+			// errors of the part take precedence, as writing
+			// to it fails once it returned early
+			if partErr := <-result; partErr != nil {
+				return partErr
+			} else if err != nil {
+				return err
+			}
+
+			if !more {
+				return nil
+			}
+		}
+	}
+}
+
+// splitter reads the stream line by line
+// and writes the lines chunk by chunk.
+type splitter struct {
+	*lineReader
+
+	maxBytes int64
+	maxLines int
+}
+
+// chunk writes lines to w until a limit is reached
+// and reports if there are lines left for the next chunk.
+func (s *splitter) chunk(w io.Writer) (bool, error) {
+	var size int64
+
+	for lines := 0; s.maxLines <= 0 || lines < s.maxLines; lines++ {
+		if err := s.read(); err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		if lines > 0 && s.maxBytes > 0 && size+int64(len(s.line)) > s.maxBytes {
+			return true, nil
+		}
+
+		s.buffered = false
+		size += int64(len(s.line))
+
+		if _, err := w.Write(s.line); err != nil {
+			return false, err
+		}
+	}
+
+	// only start another chunk if there is something left
+	if err := s.read(); err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// lineReader reads a stream line by line. Unlike lineScanner
+// it keeps the line breaks and by default has no limit on the line length.
+type lineReader struct {
+	reader *bufio.Reader
+
+	// line read ahead, including the line break
+	line     []byte
+	buffered bool
+
+	// lines longer than max bytes are truncated, zero disables the limit
+	max int
+	// the line was truncated
+	truncated bool
+	// bytes of the line in the stream, including the truncated ones
+	size int64
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{reader: bufio.NewReader(r)}
+}
+
+// read reads the next line unless a line is buffered.
+func (l *lineReader) read() error {
+	if l.buffered {
+		return nil
+	}
+
+	l.line = l.line[:0]
+	l.truncated = false
+	l.size = 0
+	for {
+		b, err := l.reader.ReadSlice('\n')
+		l.size += int64(len(b))
+
+		if l.max > 0 && len(l.line)+len(b) > l.max {
+			// skip the rest of the line without buffering it
+			b = b[:l.max-len(l.line)]
+			l.truncated = true
+		}
+		l.line = append(l.line, b...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err == io.EOF && l.size > 0 {
+			// last line without a line break
+			err = nil
+		}
+
+		l.buffered = err == nil
+		return err
+	}
+}
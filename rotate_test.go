@@ -0,0 +1,83 @@
+package pipeline_test
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToRotatingFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	r := strings.NewReader("1\n2\n3\n4\n5")
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToRotatingFiles(filepath.Join(dir, "part-%05d.csv.gz"), 0, 2).
+		Preamble("id\n").
+		CompressGzip(true).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "part-00001.csv.gz"),
+		filepath.Join(dir, "part-00002.csv.gz"),
+		filepath.Join(dir, "part-00003.csv.gz"),
+	}, paths)
+
+	// every part is compressed on its own and starts with the preamble
+	expected := []string{"id\n1\n2\n", "id\n3\n4\n", "id\n5"}
+	for i, path := range paths {
+		var out strings.Builder
+		err := pipeline.Build().FromFile(path).DecompressGzip(true).
+			ToWriter(&out).Build().Execute()
+		assert.NoError(t, err)
+		assert.Equal(t, expected[i], out.String())
+	}
+}
+
+func TestToRotatingFilesMaxBytes(t *testing.T) {
+	fsys := newMemFS()
+
+	r := strings.NewReader("aaaa\nbb\ncc\nddddddddd\ne\n")
+	err := pipeline.Build().FromReader(r, r.Size()).
+		ToRotatingFiles("part-%d.txt", 8, 0, pipeline.FileSystem(fsys)).
+		Build().Execute()
+	assert.NoError(t, err)
+
+	assert.Len(t, fsys.names(), 4)
+	assert.Equal(t, "aaaa\nbb\n", fsys.content("part-1.txt"))
+	assert.Equal(t, "cc\n", fsys.content("part-2.txt"))
+	// longer lines get a file of their own
+	assert.Equal(t, "ddddddddd\n", fsys.content("part-3.txt"))
+	assert.Equal(t, "e\n", fsys.content("part-4.txt"))
+
+	// an empty stream creates a single file
+	fsys = newMemFS()
+	err = pipeline.Build().FromReader(strings.NewReader(""), 0).
+		ToRotatingFiles("part-%d.txt", 8, 0, pipeline.FileSystem(fsys)).
+		Appendix("end").
+		Build().Execute()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"part-1.txt"}, fsys.names())
+	assert.Equal(t, "end", fsys.content("part-1.txt"))
+}
+
+func TestToRotatingFilesError(t *testing.T) {
+	fsys := newMemFS()
+	failure := errors.New("failure")
+
+	r := io.MultiReader(strings.NewReader("a\nb\nc\n"), readerFunc(func(p []byte) (int, error) {
+		return 0, failure
+	}))
+
+	err := pipeline.Build().FromReader(r, -1).
+		ToRotatingFiles("part-%d.txt", 0, 1, pipeline.FileSystem(fsys)).
+		Build().Execute()
+	assert.ErrorIs(t, err, failure)
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// RoutePredicate decides if a line is sent to a route.
+// The line is passed without its line break
+// and is only valid during the call.
+type RoutePredicate func(line []byte) bool
+
+// MatchJSON decodes the line as json into a record of type T
+// and matches the record. Lines that are no valid json are not matched.
+func MatchJSON[T any](match func(T) bool) RoutePredicate {
+	return func(line []byte) bool {
+		var record T
+		if err := json.Unmarshal(line, &record); err != nil {
+			return false
+		}
+
+		return match(record)
+	}
+}
+
+// Route is a branch of RouteLines.
+type Route struct {
+	// Match selects the lines of the route,
+	// a route without Match receives every line
+	Match RoutePredicate
+
+	// Default routes receive the lines no route matched
+	Default bool
+
+	Next Reader
+}
+
+// RouteLines sends every line only to the routes matching it.
+// Like MultiProcess every route runs in its own goroutine,
+// but a route does not see the lines it is not interested in.
+func RouteLines(routes ...Route) Reader {
+	return routeLines(routes, fanoutConfig{})
+}
+
+func routeLines(routes []Route, config fanoutConfig) Reader {
+	next := make([]Reader, len(routes))
+	for i, route := range routes {
+		next[i] = route.Next
+	}
+
+	return multiProcess(next, config, func(writers []io.Writer, r io.Reader) error {
+		lines := newLineReader(r)
+
+		for {
+			if err := lines.read(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			lines.buffered = false
+
+			line := bytes.TrimSuffix(bytes.TrimSuffix(lines.line, []byte("\n")), []byte("\r"))
+
+			matched := false
+			for i, route := range routes {
+				send := !route.Default && (route.Match == nil || route.Match(line))
+				if !send {
+					continue
+				}
+
+				matched = matched || route.Match != nil
+				if _, err := writers[i].Write(lines.line); err != nil {
+					return err
+				}
+			}
+
+			if matched {
+				continue
+			}
+
+			for i, route := range routes {
+				if !route.Default {
+					continue
+				}
+
+				if _, err := writers[i].Write(lines.line); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/paulheg/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFanoutRoute(t *testing.T) {
+	r := strings.NewReader("ERROR a\nWARN b\nINFO c\nERROR d\r\nDEBUG e")
+
+	var errors, warnings, other, all strings.Builder
+
+	prefix := func(p string) pipeline.RoutePredicate {
+		return func(line []byte) bool {
+			return bytes.HasPrefix(line, []byte(p))
+		}
+	}
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		Fanout().
+		Route(prefix("ERROR"), func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&errors).Build()
+		}).
+		Route(prefix("WARN"), func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&warnings).Build()
+		}).
+		Default(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&other).Build()
+		}).
+		Register(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&all).Build()
+		}).
+		Build().Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "ERROR a\nERROR d\r\n", errors.String())
+	assert.Equal(t, "WARN b\n", warnings.String())
+	assert.Equal(t, "INFO c\nDEBUG e", other.String())
+	assert.Equal(t, "ERROR a\nWARN b\nINFO c\nERROR d\r\nDEBUG e", all.String())
+}
+
+func TestFanoutRouteJSON(t *testing.T) {
+	type event struct {
+		Level string
+	}
+
+	r := strings.NewReader("{\"Level\":\"error\"}\n{\"Level\":\"info\"}\nnot json\n")
+
+	var errors, other strings.Builder
+
+	err := pipeline.Build().FromReader(r, r.Size()).
+		Fanout().
+		Route(pipeline.MatchJSON(func(e event) bool {
+			return e.Level == "error"
+		}), func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&errors).Build()
+		}).
+		Default(func(output pipeline.OutputBuilder) pipeline.Pipeline {
+			return output.ToWriter(&other).Build()
+		}).
+		Build().Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"Level\":\"error\"}\n", errors.String())
+	assert.Equal(t, "{\"Level\":\"info\"}\nnot json\n", other.String())
+}